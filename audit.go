@@ -0,0 +1,62 @@
+// Package main implements append-only audit logging of chain mutations
+package main
+
+import (
+	"bytes"         // for combining byte slices
+	"crypto/sha256" // for chaining entry hashes
+	"time"          // for entry timestamps
+)
+
+// AuditEntry records a single mutating operation on the chain
+type AuditEntry struct {
+	Timestamp time.Time // when the operation happened
+	Operation string    // what was done, e.g. "AddBlock"
+	Details   string    // human-readable specifics
+	PrevHash  []byte    // hash of the previous entry, empty for the first
+	Hash      []byte    // hash over this entry and PrevHash
+}
+
+// AuditLog is an append-only sink for audit entries
+type AuditLog interface {
+	// Append records an entry; entries are never modified or removed
+	Append(entry AuditEntry) error
+}
+
+// MemoryAuditLog is an AuditLog kept in memory
+type MemoryAuditLog struct {
+	entries []AuditEntry // recorded entries in order
+}
+
+// Append records an entry
+func (l *MemoryAuditLog) Append(entry AuditEntry) error {
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// Entries returns a copy of the recorded entries in order
+func (l *MemoryAuditLog) Entries() []AuditEntry {
+	return append([]AuditEntry(nil), l.entries...)
+}
+
+// newAuditEntry builds an entry chained to the previous entry's hash, so
+// removing or editing an entry breaks every hash after it
+func newAuditEntry(prevHash []byte, operation, details string) AuditEntry {
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		Operation: operation,
+		Details:   details,
+		PrevHash:  prevHash,
+	}
+
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{
+			prevHash,
+			IntToHex(entry.Timestamp.UnixNano()),
+			[]byte(operation),
+			[]byte(details),
+		},
+		[]byte{},
+	))
+	entry.Hash = hash[:]
+	return entry
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"   // for comparing hashes
+	"errors"  // for the failing log's error
+	"testing" // for the test harness
+)
+
+// failingAuditLog is an AuditLog that can't record anything
+type failingAuditLog struct{}
+
+// Append always fails
+func (failingAuditLog) Append(AuditEntry) error {
+	return errors.New("disk full")
+}
+
+func TestAuditLogRecordsMutationsInOrder(t *testing.T) {
+	bc := newTestChain(t, POW)
+	log := &MemoryAuditLog{}
+	bc.SetAuditLog(log)
+
+	addTestBlocks(t, bc, 2)
+	if err := bc.SwitchConsensus(POS); err != nil {
+		t.Fatalf("SwitchConsensus: %v", err)
+	}
+
+	entries := log.Entries()
+	want := []string{"AddBlock", "AddBlock", "SwitchConsensus"}
+	if len(entries) != len(want) {
+		t.Fatalf("audit log holds %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Operation != want[i] {
+			t.Errorf("entry %d is %s, want %s", i, entry.Operation, want[i])
+		}
+		if i > 0 && !bytes.Equal(entry.PrevHash, entries[i-1].Hash) {
+			t.Errorf("entry %d is not chained to entry %d", i, i-1)
+		}
+	}
+}
+
+func TestFailedAuditLeavesChainUnchanged(t *testing.T) {
+	bc := newTestChain(t, POW)
+	longer := forkTestChain(t, bc)
+	addTestBlocks(t, longer, 2)
+	blocks, err := longer.chain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := tipBlock(t, bc)
+	bc.SetAuditLog(failingAuditLog{})
+
+	if err := bc.AddBlock(nil); err == nil {
+		t.Fatal("AddBlock succeeded without an audit entry")
+	}
+	if err := bc.SwitchConsensus(POS); err == nil {
+		t.Fatal("SwitchConsensus succeeded without an audit entry")
+	}
+	if err := bc.ReplaceChain(blocks); err == nil {
+		t.Fatal("ReplaceChain succeeded without an audit entry")
+	}
+
+	if tip := tipBlock(t, bc); !bytes.Equal(tip.Hash, genesis.Hash) {
+		t.Fatalf("tip moved to %x without an audit entry", tip.Hash)
+	}
+	if got := bc.consensusAt(1); got != POW {
+		t.Fatalf("consensus at height 1 is %s, want PoW", got)
+	}
+}
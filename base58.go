@@ -0,0 +1,63 @@
+// Package main implements Base58 encoding for addresses
+package main
+
+import (
+	"bytes"    // for building and trimming encodings
+	"fmt"      // for error messages
+	"math/big" // for base conversion
+)
+
+// b58Alphabet omits characters that look alike (0, O, I and l)
+var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+
+// Base58Encode encodes bytes to Base58
+func Base58Encode(input []byte) []byte {
+	var result []byte
+
+	x := new(big.Int).SetBytes(input)
+	base := big.NewInt(int64(len(b58Alphabet)))
+	zero := big.NewInt(0)
+	mod := new(big.Int)
+
+	for x.Cmp(zero) != 0 {
+		x.DivMod(x, base, mod)
+		result = append(result, b58Alphabet[mod.Int64()])
+	}
+
+	// Leading zero bytes are encoded as the first alphabet character
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+		result = append(result, b58Alphabet[0])
+	}
+
+	// Digits were produced least significant first
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// Base58Decode decodes Base58 data back to bytes
+func Base58Decode(input []byte) ([]byte, error) {
+	result := big.NewInt(0)
+	base := big.NewInt(int64(len(b58Alphabet)))
+
+	for _, b := range input {
+		index := bytes.IndexByte(b58Alphabet, b)
+		if index == -1 {
+			return nil, fmt.Errorf("invalid base58 character %q", b)
+		}
+		result.Mul(result, base)
+		result.Add(result, big.NewInt(int64(index)))
+	}
+
+	// Restore the leading zero bytes
+	var zeros int
+	for zeros < len(input) && input[zeros] == b58Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), result.Bytes()...), nil
+}
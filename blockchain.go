@@ -2,97 +2,1447 @@
 package main
 
 import (
-	"fmt"     // for printing
-	"strconv" // for converting bool to string
-	"time"    // for block timestamps
+	"bytes"         // for comparing byte slices
+	"context"       // for cancelling block production
+	"crypto/ecdsa"  // for signing transactions
+	"crypto/sha256" // for hashing
+	"encoding/gob"  // for serializing blocks
+	"encoding/hex"  // for keying transactions by ID
+	"errors"        // for sentinel errors
+	"fmt"           // for printing
+	"io"            // for closing stores
+	"math/big"      // for cumulative difficulty
+	"os"            // for command-line arguments
+	"strings"       // for building block descriptions
+	"sync"          // for guarding the chain
+	"time"          // for block timestamps
 )
 
+// Block versions follow BIP9: the top three bits are 001, and each of the
+// remaining low bits can be set by producers to signal readiness for a
+// soft-fork upgrade
+const (
+	versionBitsTopBits = 0x20000000 // top bits marking a version as carrying signal bits
+	versionBitsTopMask = 0xe0000000 // selects the top bits
+	versionBitsCount   = 29         // number of low bits available for signaling
+)
+
+// BlockVersion is the version new blocks are produced with before any signal
+// bits are set
+const BlockVersion int32 = versionBitsTopBits
+
+const (
+	targetBlockTime  = 10 // seconds between blocks that difficulty adjustment aims for
+	difficultyWindow = 10 // number of recent block intervals averaged when retargeting
+)
+
+// defaultMaxFutureDrift is how far ahead of the local clock a block's
+// timestamp may be, allowing for clock skew between nodes
+const defaultMaxFutureDrift = 2 * time.Hour
+
+// defaultMaxBlockSize is the largest serialized block, in bytes, a chain
+// accepts unless configured otherwise
+const defaultMaxBlockSize = 1 << 20
+
+// ErrChainPaused is returned when adding blocks while the chain is paused
+var ErrChainPaused = errors.New("chain paused")
+
 // Block represents each 'item' in the blockchain
 type Block struct {
-	Timestamp     int64  // when the block was created
-	Data          []byte // the actual data/transactions in the block
-	PrevBlockHash []byte // the hash of the previous block
-	Hash          []byte // the hash of the current block
-	ValidatorID   []byte // ID of miner (PoW) or validator (PoS)
+	Version           int32          // block format version and soft-fork signal bits
+	Timestamp         int64          // when the block was created
+	Transactions      []*Transaction // the transactions in the block
+	PrevBlockHash     []byte         // the hash of the previous block
+	Height            int            // position in the chain, genesis is 0
+	Hash              []byte         // the hash of the current block
+	ValidatorID       []byte         // ID of miner (PoW) or validator (PoS)
+	TargetBits        int            // PoW difficulty the block was mined at
+	Consensus         ConsensusType  // consensus mechanism the block was produced with
+	Witness           []byte         // optional segregated data, not covered by Hash
+	WitnessCommitment []byte         // WitnessHash of Witness, covered by Hash (empty without a witness)
+	Signature         []byte         // producer's signature, if the consensus uses one
+	ValidatorStake    uint64         // PoS producer's stake when it was selected
+	TotalStake        uint64         // total PoS stake the producer was selected from
+	ValidatorReward   uint64         // amount credited to the PoS producer for forging the block
 }
 
-// Blockchain is a series of validated Blocks
+// Blockchain is a series of validated Blocks. It is safe for concurrent use:
+// exported methods lock mu, and unexported ones expect it to be held.
 type Blockchain struct {
-	blocks        []*Block      // slice of pointers to Block
-	consensusType ConsensusType // type of consensus mechanism to use
+	mu              sync.RWMutex        // guards the fields below
+	store           Store               // where the blocks are kept
+	utxo            *UTXOSet            // unspent outputs as of the tip
+	schedule        []consensusSwitch   // consensus in force from each height, genesis first
+	targetBits      int                 // PoW difficulty for the genesis block
+	minTargetBits   int                 // floor that difficulty adjustment never goes below
+	maxFutureDrift  time.Duration       // how far in the future block timestamps may be
+	maxBlockSize    int                 // largest serialized block accepted after genesis
+	lockHeight      int                 // height from which consensus can't be switched (0 = never locked)
+	paused          bool                // when set, no new blocks are produced
+	events          *EventBus           // publishes chain events to subscribers
+	quarantine      []*Block            // received blocks awaiting validation
+	auditLog        AuditLog            // optional record of chain mutations
+	lastAudit       []byte              // hash of the last audit entry
+	logger          Logger              // receives status messages
+	initialReward   uint64              // block reward before the first halving
+	halvingInterval int                 // blocks between reward halvings
+	rewardAddress   []byte              // where coinbases of new blocks pay, if set
+	versionBits     int32               // soft-fork bits signaled by new blocks
+	watchdog        time.Duration       // how long mining a block may run before onStuck fires (0 = disabled)
+	onStuck         func(time.Duration) // called when mining a block exceeds watchdog
+	requireCoinbase bool                // whether blocks after genesis must start with a coinbase
+	validatorReward uint64              // credited to the forging validator of each PoS block
+	mempool         *Mempool            // pool made by NewMempool, if any
+}
+
+// NewBlock creates and returns a new Block, stopping early if ctx is cancelled
+func NewBlock(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height int, consensusType ConsensusType, targetBits int) (*Block, error) {
+	return forgeBlock(ctx, transactions, prevBlockHash, height, time.Now().Unix(), nil, consensusType, targetBits, nil)
+}
+
+// forgeBlock is NewBlock for a block with the given timestamp, following one
+// produced by prevValidatorID. A non-nil logger receives consensus progress.
+func forgeBlock(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height int, timestamp int64, prevValidatorID []byte, consensusType ConsensusType, targetBits int, logger Logger) (*Block, error) {
+	block := unsealedBlock(transactions, prevBlockHash, height, timestamp, consensusType, targetBits)
+	consensus := newConsensusAfter(consensusType, block, prevValidatorID, logger)
+	if err := sealBlock(ctx, block, consensus); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
-// NewBlock creates and returns a new Block
-func NewBlock(data string, prevBlockHash []byte, consensusType ConsensusType) *Block {
-	block := &Block{
-		Timestamp:     time.Now().Unix(),
-		Data:          []byte(data),
+// unsealedBlock assembles a block that has yet to be produced by its
+// consensus mechanism
+func unsealedBlock(transactions []*Transaction, prevBlockHash []byte, height int, timestamp int64, consensusType ConsensusType, targetBits int) *Block {
+	return &Block{
+		Version:       BlockVersion,
+		Timestamp:     timestamp,
+		Transactions:  transactions,
 		PrevBlockHash: prevBlockHash,
+		Height:        height,
 		Hash:          []byte{},
 		ValidatorID:   []byte{},
+		TargetBits:    targetBits,
+		Consensus:     consensusType,
 	}
+}
 
-	// Create consensus mechanism and run it
-	consensus := NewConsensus(consensusType, block)
-	validatorID, hash := consensus.Run()
+// sealBlock runs consensus, built for block, and sets the block's hash and
+// validator ID
+func sealBlock(ctx context.Context, block *Block, consensus Consensus) error {
+	validatorID, hash, err := consensus.Run(ctx)
+	if err != nil {
+		return err
+	}
 
 	// Set the block's hash and validator ID
 	block.Hash = hash
 	block.ValidatorID = validatorID
+	return nil
+}
+
+// ComputeHash deterministically hashes the block's contents. Consensus
+// mechanisms hash the same fields, so a block whose Hash differs from
+// ComputeHash has been altered since it was produced.
+func (b *Block) ComputeHash() []byte {
+	hash := sha256.Sum256(b.hashData(b.ValidatorID))
+	return hash[:]
+}
+
+// hashData combines the block fields covered by its hash. The validator ID is
+// passed in so consensus can try candidates without mutating the block.
+func (b *Block) hashData(validatorID []byte) []byte {
+	return bytes.Join(
+		[][]byte{
+			IntToHex(int64(b.Version)),
+			b.PrevBlockHash,
+			IntToHex(int64(b.Height)),
+			HashTransactions(b.Transactions),
+			IntToHex(b.Timestamp),
+			IntToHex(int64(b.TargetBits)),
+			IntToHex(int64(b.Consensus)),
+			IntToHex(int64(b.ValidatorStake)),
+			IntToHex(int64(b.TotalStake)),
+			IntToHex(int64(b.ValidatorReward)),
+			b.WitnessCommitment,
+			validatorID,
+		},
+		[]byte{},
+	)
+}
+
+// Serialize encodes the block into bytes
+func (b *Block) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+	if err := encoder.Encode(b); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// DeserializeBlock decodes a block produced by Serialize
+func DeserializeBlock(data []byte) (*Block, error) {
+	var block Block
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// WitnessHash commits to the block's witness data. It is kept separate from
+// Hash so validators that don't know about witnesses are unaffected by them.
+func (b *Block) WitnessHash() []byte {
+	hash := sha256.Sum256(b.Witness)
+	return hash[:]
+}
+
+// SetWitness attaches witness data to a block that is still being built,
+// recording its WitnessHash in WitnessCommitment. It must be called before
+// the block is hashed.
+func (b *Block) SetWitness(witness []byte) {
+	b.Witness = witness
+	b.WitnessCommitment = nil
+	if len(witness) > 0 {
+		b.WitnessCommitment = b.WitnessHash()
+	}
+}
+
+// checkWitness rejects a block whose witness doesn't match the commitment
+// covered by its hash
+func checkWitness(block *Block) error {
+	if len(block.Witness) == 0 && len(block.WitnessCommitment) == 0 {
+		return nil
+	}
+	if !bytes.Equal(block.WitnessCommitment, block.WitnessHash()) {
+		return fmt.Errorf("witness hash %x does not match commitment %x", block.WitnessHash(), block.WitnessCommitment)
+	}
+	return nil
+}
+
+// String describes the block in a human-readable, multi-line form
+func (b *Block) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Height: %d\n", b.Height)
+	fmt.Fprintf(&sb, "Version: %d\n", b.Version)
+	fmt.Fprintf(&sb, "Timestamp: %s\n", time.Unix(b.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "Prev. hash: %x\n", b.PrevBlockHash)
+	fmt.Fprintf(&sb, "Hash: %x\n", b.Hash)
+	fmt.Fprintf(&sb, "Consensus: %s\n", b.Consensus)
+
+	// PoW stores the nonce in ValidatorID, the other mechanisms an address
+	if nonce, err := IntFromHex(b.ValidatorID); b.Consensus == POW && err == nil {
+		fmt.Fprintf(&sb, "Nonce: %d\n", nonce)
+	} else {
+		fmt.Fprintf(&sb, "Validator ID: %s\n", b.ValidatorID)
+	}
+	if b.Consensus == POS {
+		fmt.Fprintf(&sb, "Stake: %d of %d (p=%.3f)\n", b.ValidatorStake, b.TotalStake, b.SelectionProbability())
+		fmt.Fprintf(&sb, "Validator reward: %d\n", b.ValidatorReward)
+	}
 
-	return block
+	fmt.Fprintf(&sb, "Transactions: %d", len(b.Transactions))
+	for _, tx := range b.Transactions {
+		fmt.Fprintf(&sb, "\n  %x", tx.ID)
+		if len(tx.Data) > 0 {
+			fmt.Fprintf(&sb, " Data: %q", tx.Data)
+		}
+	}
+	return sb.String()
+}
+
+// SelectionProbability returns the chance a PoS producer had of being
+// selected, given the stake recorded in the block
+func (b *Block) SelectionProbability() float64 {
+	if b.TotalStake == 0 {
+		return 0
+	}
+	return float64(b.ValidatorStake) / float64(b.TotalStake)
 }
 
 // NewGenesisBlock creates and returns the genesis Block
-func NewGenesisBlock(consensusType ConsensusType) *Block {
-	return NewBlock("Genesis Block", []byte{}, consensusType)
+func NewGenesisBlock(consensusType ConsensusType, targetBits int) (*Block, error) {
+	return NewBlock(context.Background(), []*Transaction{}, []byte{}, 0, consensusType, targetBits)
+}
+
+// GenesisConfig customizes the genesis block of a new chain
+type GenesisConfig struct {
+	Data      string // message embedded in the genesis block
+	Timestamp int64  // genesis block timestamp
+}
+
+// NewGenesisBlockWith creates a genesis Block with the given timestamp,
+// carrying data in a transaction without inputs or outputs. Chains started
+// from different data or timestamps have different genesis hashes.
+func NewGenesisBlockWith(data string, timestamp int64, consensusType ConsensusType, targetBits int) (*Block, error) {
+	tx := &Transaction{Data: []byte(data)}
+	tx.ID = tx.Hash()
+
+	return forgeBlock(context.Background(), []*Transaction{tx}, []byte{}, 0, timestamp, nil, consensusType, targetBits, nil)
+}
+
+// NewBlockchain opens the blockchain stored in the BoltDB file at dbPath,
+// creating it with a genesis Block if the file holds no chain yet. A new
+// chain uses the given consensus and genesis difficulty; an existing one
+// keeps the consensus it was created or switched to. An optional
+// GenesisConfig customizes the genesis Block.
+func NewBlockchain(dbPath string, consensusType ConsensusType, targetBits int, genesis ...GenesisConfig) (*Blockchain, error) {
+	store, err := NewBoltStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := NewBlockchainWithStore(store, consensusType, targetBits, genesis...)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+// NewBlockchainWithStore creates a Blockchain backed by the given store,
+// adding a genesis Block, customized by the optional GenesisConfig, if the
+// store is empty. As with NewBlockchain, consensusType and targetBits only
+// apply to a new chain.
+func NewBlockchainWithStore(store Store, consensusType ConsensusType, targetBits int, genesis ...GenesisConfig) (*Blockchain, error) {
+	bc := &Blockchain{
+		store:           store,
+		targetBits:      targetBits,
+		events:          NewEventBus(),
+		utxo:            NewUTXOSet(),
+		maxFutureDrift:  defaultMaxFutureDrift,
+		maxBlockSize:    defaultMaxBlockSize,
+		logger:          nopLogger{},
+		initialReward:   defaultInitialReward,
+		halvingInterval: defaultHalvingInterval,
+	}
+
+	tip, err := store.Tip()
+	if err != nil {
+		return nil, err
+	}
+	if tip == nil {
+		var genesisBlock *Block
+		if len(genesis) > 0 {
+			genesisBlock, err = NewGenesisBlockWith(genesis[0].Data, genesis[0].Timestamp, consensusType, targetBits)
+		} else {
+			genesisBlock, err = NewGenesisBlock(consensusType, targetBits)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.appendBlock(genesisBlock); err != nil {
+			return nil, err
+		}
+		if err := bc.loadSchedule(genesisBlock); err != nil {
+			return nil, err
+		}
+	} else {
+		// Rebuild the UTXO set from the existing chain
+		blocks, err := bc.chain()
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.loadSchedule(blocks[0]); err != nil {
+			return nil, err
+		}
+		bc.utxo.Reindex(blocks)
+	}
+
+	return bc, nil
+}
+
+// Close releases the underlying store, if it holds any resources
+func (bc *Blockchain) Close() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if closer, ok := bc.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// appendBlock stores the block, makes it the new tip and applies it to the
+// UTXO set
+func (bc *Blockchain) appendBlock(block *Block) error {
+	if err := bc.store.Put(block); err != nil {
+		return err
+	}
+	if err := bc.store.SetTip(block.Hash); err != nil {
+		return err
+	}
+
+	bc.utxo.Update(block)
+	return nil
+}
+
+// UTXOSet returns the unspent transaction outputs as of the tip. The set
+// changes as blocks are added, so it must not be read while another
+// goroutine may be adding them.
+func (bc *Blockchain) UTXOSet() *UTXOSet {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.utxo
+}
+
+// AddBlock adds a new block with the given transactions to the blockchain
+func (bc *Blockchain) AddBlock(transactions []*Transaction) error {
+	return bc.AddBlockContext(context.Background(), transactions)
+}
+
+// AddBlockContext is AddBlock, giving up if ctx is cancelled before the
+// block is produced
+func (bc *Blockchain) AddBlockContext(ctx context.Context, transactions []*Transaction) error {
+	_, err := bc.addBlock(ctx, transactions)
+	return err
+}
+
+// addBlock is AddBlockContext, also returning the new block. Unlike other
+// unexported methods, it locks bc.mu itself.
+func (bc *Blockchain) addBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.paused {
+		return nil, ErrChainPaused
+	}
+
+	tip, err := bc.store.Tip()
+	if err != nil {
+		return nil, err
+	}
+	prevBlock, err := bc.store.Get(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	height := prevBlock.Height + 1
+	fees, err := bc.checkTransactions(transactions, height, bc.utxo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pay the block reward and fees first. The transactions were checked
+	// without it, so none of them may be a coinbase too.
+	if coinbase := bc.coinbaseFor(height, fees); coinbase != nil {
+		if len(transactions) > 0 && transactions[0].IsCoinbase() {
+			return nil, fmt.Errorf("transaction %x creates coins but is not the coinbase", transactions[0].ID)
+		}
+		transactions = append([]*Transaction{coinbase}, transactions...)
+	}
+
+	targetBits, err := bc.nextDifficulty()
+	if err != nil {
+		return nil, err
+	}
+	newBlock := unsealedBlock(transactions, prevBlock.Hash, height, time.Now().Unix(), bc.consensusAt(height), targetBits)
+	newBlock.Version |= bc.versionBits
+	// Sealing only adds a little, so don't spend work on a block already
+	// too large. The sealed block is checked again below.
+	if err := bc.checkSize(newBlock); err != nil {
+		return nil, err
+	}
+	consensus := newConsensusAfter(newBlock.Consensus, newBlock, prevBlock.ValidatorID, bc.logger)
+	if setter, ok := consensus.(watchdogSetter); ok && bc.watchdog > 0 {
+		setter.SetWatchdog(bc.watchdog, bc.onStuck)
+	}
+	if setter, ok := consensus.(blockRewardSetter); ok {
+		setter.SetBlockReward(bc.validatorReward)
+	}
+	if err := sealBlock(ctx, newBlock, consensus); err != nil {
+		return nil, err
+	}
+	if err := bc.checkTimestamp(newBlock, prevBlock); err != nil {
+		return nil, err
+	}
+	if err := bc.checkSize(newBlock); err != nil {
+		return nil, err
+	}
+	if err := bc.checkCoinbase(newBlock); err != nil {
+		return nil, err
+	}
+
+	if err := bc.connectBlock(newBlock, "AddBlock"); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
+}
+
+// SetLogger routes status messages from the chain and the consensus
+// mechanisms producing its blocks to logger
+func (bc *Blockchain) SetLogger(logger Logger) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.logger = logger
+}
+
+// Logger returns the logger set by SetLogger, for components such as Node
+// that report on the chain's behalf
+func (bc *Blockchain) Logger() Logger {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.logger
+}
+
+// SetMiningWatchdog makes AddBlock call onStuck if mining a block runs longer
+// than d, as ProofOfWork.SetWatchdog does. onStuck runs on its own goroutine
+// while the chain is locked for mining, so it must not call back into the
+// chain. A d of 0 disables the watchdog.
+func (bc *Blockchain) SetMiningWatchdog(d time.Duration, onStuck func(elapsed time.Duration)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.watchdog = d
+	bc.onStuck = onStuck
+}
+
+// SetAuditLog makes the chain record every mutation to log. Each mutation is
+// recorded before it is made, and abandoned if recording fails.
+func (bc *Blockchain) SetAuditLog(log AuditLog) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.auditLog = log
+}
+
+// audit records an operation to the audit log, if one is set
+func (bc *Blockchain) audit(operation, details string) error {
+	if bc.auditLog == nil {
+		return nil
+	}
+
+	entry := newAuditEntry(bc.lastAudit, operation, details)
+	if err := bc.auditLog.Append(entry); err != nil {
+		return fmt.Errorf("audit %s: %w", operation, err)
+	}
+	bc.lastAudit = entry.Hash
+	return nil
+}
+
+// Quarantine accepts a block without validating it. Quarantined blocks are
+// only connected to the chain by ProcessQuarantine.
+func (bc *Blockchain) Quarantine(block *Block) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.quarantine = append(bc.quarantine, block)
 }
 
-// NewBlockchain creates a new Blockchain with genesis Block
-func NewBlockchain(consensusType ConsensusType) *Blockchain {
-	return &Blockchain{
-		blocks:        []*Block{NewGenesisBlock(consensusType)},
-		consensusType: consensusType,
+// ProcessQuarantine validates quarantined blocks and connects them to the tip
+// in chain order. Invalid blocks are discarded; blocks that don't connect yet
+// stay quarantined.
+func (bc *Blockchain) ProcessQuarantine() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.paused {
+		return ErrChainPaused
+	}
+
+	for {
+		tip, err := bc.store.Tip()
+		if err != nil {
+			return err
+		}
+
+		// Find the quarantined block that builds on the current tip
+		next := -1
+		for i, block := range bc.quarantine {
+			if bytes.Equal(block.PrevBlockHash, tip) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			return nil
+		}
+
+		block := bc.quarantine[next]
+		bc.quarantine = append(bc.quarantine[:next], bc.quarantine[next+1:]...)
+
+		prevBlock, err := bc.store.Get(tip)
+		if err != nil {
+			return err
+		}
+		if err := bc.checkBlock(block, prevBlock); err != nil {
+			bc.logger.Printf("Rejected quarantined block %x: %v", block.Hash, err)
+			continue
+		}
+
+		if err := bc.connectBlock(block, "ProcessQuarantine"); err != nil {
+			return err
+		}
 	}
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(data string) {
-	prevBlock := bc.blocks[len(bc.blocks)-1]
-	newBlock := NewBlock(data, prevBlock.Hash, bc.consensusType)
-	bc.blocks = append(bc.blocks, newBlock)
+// AcceptBlock validates a block received from elsewhere and appends it, as
+// long as it builds on the current tip
+func (bc *Blockchain) AcceptBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.paused {
+		return ErrChainPaused
+	}
+
+	tip, err := bc.store.Tip()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.PrevBlockHash, tip) {
+		return fmt.Errorf("block %x does not build on tip %x", block.Hash, tip)
+	}
+	prevBlock, err := bc.store.Get(tip)
+	if err != nil {
+		return err
+	}
+	if err := bc.checkBlock(block, prevBlock); err != nil {
+		return fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+
+	return bc.connectBlock(block, "AcceptBlock")
 }
 
-// SwitchConsensus changes the consensus mechanism
-func (bc *Blockchain) SwitchConsensus(newType ConsensusType) {
-	bc.consensusType = newType
+// checkBlock validates a block produced elsewhere as the successor of
+// prevBlock, which must be the tip: the block's transactions are checked
+// against the outputs unspent there
+func (bc *Blockchain) checkBlock(block, prevBlock *Block) error {
+	if !bytes.Equal(block.Hash, block.ComputeHash()) {
+		return fmt.Errorf("hash %x does not match contents", block.Hash)
+	}
+	if err := checkWitness(block); err != nil {
+		return err
+	}
+	if block.Height != prevBlock.Height+1 {
+		return fmt.Errorf("height %d does not follow parent height %d", block.Height, prevBlock.Height)
+	}
+	parents, err := bc.chainTo(prevBlock.Hash)
+	if err != nil {
+		return err
+	}
+	if err := bc.checkDifficulty(block, parents); err != nil {
+		return err
+	}
+	if err := bc.checkTimestamp(block, prevBlock); err != nil {
+		return err
+	}
+	if err := bc.checkSize(block); err != nil {
+		return err
+	}
+	if err := bc.checkCoinbase(block); err != nil {
+		return err
+	}
+	if err := bc.checkValidatorReward(block); err != nil {
+		return err
+	}
+	if err := bc.checkConsensus(block); err != nil {
+		return err
+	}
+
+	consensus := newConsensusAfter(block.Consensus, block, prevBlock.ValidatorID, nil)
+	valid, err := consensus.Validate()
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("consensus validation failed")
+	}
+
+	_, err = bc.checkTransactions(block.Transactions, block.Height, bc.utxo)
+	return err
+}
+
+// connectBlock appends a validated block and announces it, recording
+// operation in the audit log first so a failing log leaves the chain as it
+// was
+func (bc *Blockchain) connectBlock(block *Block, operation string) error {
+	if err := bc.audit(operation, fmt.Sprintf("block %x", block.Hash)); err != nil {
+		return err
+	}
+	if err := bc.appendBlock(block); err != nil {
+		return err
+	}
+	bc.logger.Printf("Added block %d: %x", block.Height, block.Hash)
+	bc.events.Publish(Event{Type: BlockAdded, Block: block, Consensus: block.Consensus})
+	return nil
+}
+
+// SetMaxFutureDrift sets how far ahead of the local clock a block's
+// timestamp may be
+func (bc *Blockchain) SetMaxFutureDrift(d time.Duration) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.maxFutureDrift = d
+}
+
+// checkTimestamp rejects a block dated before its parent, or too far in the
+// future. parent is nil for genesis.
+func (bc *Blockchain) checkTimestamp(block, parent *Block) error {
+	if parent != nil && block.Timestamp < parent.Timestamp {
+		return fmt.Errorf("timestamp %d is before parent timestamp %d", block.Timestamp, parent.Timestamp)
+	}
+	if limit := time.Now().Add(bc.maxFutureDrift).Unix(); block.Timestamp > limit {
+		return fmt.Errorf("timestamp %d is more than %s in the future", block.Timestamp, bc.maxFutureDrift)
+	}
+	return nil
+}
+
+// SetMaxBlockSize sets the largest serialized block, in bytes, accepted
+// after genesis
+func (bc *Blockchain) SetMaxBlockSize(size int) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.maxBlockSize = size
+}
+
+// checkSize rejects a block whose serialized form exceeds maxBlockSize
+func (bc *Blockchain) checkSize(block *Block) error {
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	if len(data) > bc.maxBlockSize {
+		return fmt.Errorf("block is %d bytes, limit is %d", len(data), bc.maxBlockSize)
+	}
+	return nil
+}
+
+// SetMinTargetBits sets the lowest difficulty adjustment may drop to
+func (bc *Blockchain) SetMinTargetBits(bits int) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.minTargetBits = bits
+}
+
+// nextDifficulty returns the targetBits for the next block
+func (bc *Blockchain) nextDifficulty() (int, error) {
+	blocks, err := bc.chain()
+	if err != nil {
+		return 0, err
+	}
+	return bc.difficultyAfter(blocks), nil
+}
+
+// difficultyAfter returns the targetBits for the block following blocks,
+// which run from genesis to its parent. For a PoW block it raises the
+// difficulty when recent PoW blocks came in much faster than
+// targetBlockTime, and lowers it (down to minTargetBits) when they came in
+// much slower. Blocks of other mechanisms do no hashing work, so they carry
+// the difficulty unchanged and their intervals are left out.
+func (bc *Blockchain) difficultyAfter(blocks []*Block) int {
+	tip := blocks[len(blocks)-1]
+	bits := tip.TargetBits
+
+	if bc.consensusAt(tip.Height+1) == POW {
+		// Average interval between consecutive PoW blocks over the last
+		// difficultyWindow blocks
+		first := len(blocks) - difficultyWindow
+		if first < 1 {
+			first = 1
+		}
+		var elapsed, intervals int64
+		for i := first; i < len(blocks); i++ {
+			if blocks[i].Consensus == POW && blocks[i-1].Consensus == POW {
+				elapsed += blocks[i].Timestamp - blocks[i-1].Timestamp
+				intervals++
+			}
+		}
+
+		if intervals > 0 {
+			average := elapsed / intervals
+			switch {
+			case average*2 < targetBlockTime:
+				bits++
+			case average > targetBlockTime*2:
+				bits--
+			}
+		}
+	}
+
+	if bits < bc.minTargetBits {
+		bits = bc.minTargetBits
+	}
+	if bits > maxTargetBits {
+		bits = maxTargetBits
+	}
+	return bits
+}
+
+// checkDifficulty rejects a block whose TargetBits differ from what
+// difficulty adjustment gives after parents, ordered from genesis. Blocks of
+// every consensus are checked, as PoW blocks inherit their predecessors'
+// difficulty.
+func (bc *Blockchain) checkDifficulty(block *Block, parents []*Block) error {
+	if want := bc.difficultyAfter(parents); block.TargetBits != want {
+		return fmt.Errorf("target bits %d, want %d", block.TargetBits, want)
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving future events of the given type, as
+// EventBus.Subscribe does. Subscribers that fall behind miss events.
+func (bc *Blockchain) Subscribe(eventType EventType) <-chan Event {
+	return bc.events.Subscribe(eventType)
+}
+
+// chain returns the blocks from genesis to tip
+func (bc *Blockchain) chain() ([]*Block, error) {
+	hash, err := bc.store.Tip()
+	if err != nil {
+		return nil, err
+	}
+	return bc.chainTo(hash)
+}
+
+// chainTo returns the blocks from genesis to the block with the given hash
+// by walking back through PrevBlockHash
+func (bc *Blockchain) chainTo(hash []byte) ([]*Block, error) {
+	var blocks []*Block
+	for len(hash) > 0 {
+		block, err := bc.store.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		hash = block.PrevBlockHash
+	}
+
+	// Reverse so that genesis comes first
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks, nil
+}
+
+// mustChain is chain for callers that can't report errors. The blocks on the
+// active chain are always in the store, so a failure here means it's corrupt.
+func (bc *Blockchain) mustChain() []*Block {
+	blocks, err := bc.chain()
+	if err != nil {
+		panic(err)
+	}
+	return blocks
+}
+
+// FindTransaction returns the transaction with the given ID from the chain
+func (bc *Blockchain) FindTransaction(id []byte) (Transaction, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.findTransaction(id)
+}
+
+// findTransaction is FindTransaction for callers holding bc.mu
+func (bc *Blockchain) findTransaction(id []byte) (Transaction, error) {
+	blocks, err := bc.chain()
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return *tx, nil
+			}
+		}
+	}
+
+	return Transaction{}, fmt.Errorf("transaction %x not found", id)
+}
+
+// previousTransactions collects the transactions whose outputs tx spends
+func (bc *Blockchain) previousTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	for _, in := range tx.Vin {
+		prevTX, err := bc.findTransaction(in.Txid)
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+	return prevTXs, nil
+}
+
+// SignTransaction signs tx's inputs with privKey, looking up the outputs
+// they spend in the chain
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	prevTXs, err := bc.previousTransactions(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Sign(privKey, prevTXs)
+}
+
+// VerifyTransaction checks that tx spends only unspent outputs, signed by
+// their owners, and creates no more than it spends. Coinbase transactions
+// fail, as only a block's coinbase may create coins. An invalid transaction
+// gives false and an error saying why.
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if _, err := bc.verifyTransaction(tx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyTransaction is VerifyTransaction, returning the fee tx pays
+func (bc *Blockchain) verifyTransaction(tx *Transaction) (int, error) {
+	return checkTransaction(tx, newUTXOView(bc.utxo))
+}
+
+// checkTransaction verifies a transaction other than a coinbase against the
+// outputs unspent in view, returning the fee it pays. view is not changed.
+func checkTransaction(tx *Transaction, view *utxoView) (int, error) {
+	fee, checks, err := checkInputs(tx, view)
+	if err != nil {
+		return 0, err
+	}
+	for _, valid := range verifySigChecks(checks) {
+		if !valid {
+			return 0, fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+		}
+	}
+	return fee, nil
+}
+
+// checkInputs is checkTransaction without verifying the signatures, which
+// it returns instead so a block's can be verified in one batch
+func checkInputs(tx *Transaction, view *utxoView) (int, []SigCheck, error) {
+	if tx.IsCoinbase() {
+		return 0, nil, fmt.Errorf("transaction %x creates coins without inputs", tx.ID)
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return 0, nil, fmt.Errorf("transaction %x ID does not match its contents", tx.ID)
+	}
+	outValue, err := tx.outputValue()
+	if err != nil {
+		return 0, nil, fmt.Errorf("transaction %x: %w", tx.ID, err)
+	}
+
+	// Every input must spend a distinct unspent output
+	inValue := 0
+	prevOuts := make([]TXOutput, len(tx.Vin))
+	spending := make(map[string]bool, len(tx.Vin))
+	for i, in := range tx.Vin {
+		key := outpoint(in.Txid, in.Vout)
+		prevOut, ok := view.output(in.Txid, in.Vout)
+		if !ok || spending[key] {
+			return 0, nil, fmt.Errorf("transaction %x spends output %d of %x, which is missing or already spent", tx.ID, in.Vout, in.Txid)
+		}
+		spending[key] = true
+		prevOuts[i] = prevOut
+		inValue += prevOut.Value
+	}
+
+	checks, ok := tx.sigChecks(prevOuts)
+	if !ok {
+		return 0, nil, fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+	}
+	if inValue < outValue {
+		return 0, nil, fmt.Errorf("transaction %x creates %d more than it spends", tx.ID, outValue-inValue)
+	}
+	return inValue - outValue, checks, nil
+}
+
+// checkTransactions verifies the transactions of a block at height against
+// the outputs unspent in utxo before it, returning the fees they pay. Only
+// the first transaction may be a coinbase, and it may pay out no more than
+// the block reward plus those fees. The signatures of all inputs are
+// verified together in one batch.
+func (bc *Blockchain) checkTransactions(txs []*Transaction, height int, utxo *UTXOSet) (int, error) {
+	view := newUTXOView(utxo)
+	fees := 0
+	var checks []SigCheck
+	var signers []*Transaction // transaction carrying each check
+	for i, tx := range txs {
+		if tx.IsCoinbase() {
+			if i > 0 {
+				return 0, fmt.Errorf("transaction %x creates coins but is not the coinbase", tx.ID)
+			}
+		} else {
+			fee, txChecks, err := checkInputs(tx, view)
+			if err != nil {
+				return 0, err
+			}
+			fees += fee
+			checks = append(checks, txChecks...)
+			for range txChecks {
+				signers = append(signers, tx)
+			}
+		}
+		view.apply(tx)
+	}
+
+	for i, valid := range verifySigChecks(checks) {
+		if !valid {
+			return 0, fmt.Errorf("transaction %x has an invalid signature", signers[i].ID)
+		}
+	}
+
+	// The coinbase is checked last, as it may claim the fees
+	if len(txs) > 0 && txs[0].IsCoinbase() {
+		coinbase := txs[0]
+		if !bytes.Equal(coinbase.ID, coinbase.Hash()) {
+			return 0, fmt.Errorf("coinbase %x ID does not match its contents", coinbase.ID)
+		}
+		value, err := coinbase.outputValue()
+		if err != nil {
+			return 0, fmt.Errorf("coinbase %x: %w", coinbase.ID, err)
+		}
+		if limit := int(bc.rewardAt(height)) + fees; value > limit {
+			return 0, fmt.Errorf("coinbase %x pays %d, more than the reward and fees of %d", coinbase.ID, value, limit)
+		}
+	}
+	return fees, nil
+}
+
+// SetPaused puts the chain in or out of maintenance mode. While paused,
+// AddBlock and AddBlockContext are rejected but the chain can still be read.
+func (bc *Blockchain) SetPaused(paused bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.paused = paused
+}
+
+// SetConsensusLockHeight forbids switching consensus once the chain reaches
+// the given height, mirroring networks that never change consensus rules.
+// A height of 0 or less removes the lock.
+func (bc *Blockchain) SetConsensusLockHeight(height int) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.lockHeight = height
+}
+
+// SwitchConsensus changes the consensus mechanism for blocks after the
+// current tip. The switch is saved with the chain, and blocks produced with
+// any other mechanism from then on are rejected.
+func (bc *Blockchain) SwitchConsensus(newType ConsensusType) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return err
+	}
+
+	height := len(blocks) - 1
+	if bc.lockHeight > 0 && height >= bc.lockHeight {
+		return fmt.Errorf("consensus is locked since height %d (current height %d)", bc.lockHeight, height)
+	}
+
+	if err := bc.audit("SwitchConsensus", fmt.Sprintf("consensus type %d", newType)); err != nil {
+		return err
+	}
+	if err := bc.scheduleSwitch(height+1, newType); err != nil {
+		return err
+	}
+	bc.logger.Printf("Switched consensus to %s", newType)
+	bc.events.Publish(Event{Type: ConsensusSwitched, Consensus: newType})
+	return nil
+}
+
+// Slash penalizes a validator of pos as ProofOfStake.Slash does, recording
+// the slashing in the audit log and publishing ValidatorSlashed
+func (bc *Blockchain) Slash(pos *ProofOfStake, address []byte, penalty uint64) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if pos.findValidator(address) == -1 {
+		return fmt.Errorf("validator %s not found", address)
+	}
+
+	if err := bc.audit("Slash", fmt.Sprintf("validator %s penalty %d", address, penalty)); err != nil {
+		return err
+	}
+	if err := pos.Slash(address, penalty); err != nil {
+		return err
+	}
+	bc.logger.Printf("Slashed validator %s by %d", address, penalty)
+	bc.events.Publish(Event{Type: ValidatorSlashed, Address: address})
+	return nil
+}
+
+// Validate checks that every block links to its predecessor and passes the
+// rules of the consensus mechanism in force at its height. The error names
+// the index of the first invalid block.
+func (bc *Blockchain) Validate() error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return err
+	}
+	return bc.validateBlocks(blocks)
+}
+
+// validateBlocks checks blocks, ordered from genesis, as Validate does
+func (bc *Blockchain) validateBlocks(blocks []*Block) error {
+	// Outputs unspent before each block, so inputs can only spend earlier
+	// outputs, and each of them only once
+	utxo := NewUTXOSet()
+
+	for i, block := range blocks {
+		if !bytes.Equal(block.Hash, block.ComputeHash()) {
+			return fmt.Errorf("block %d: hash %x does not match contents", i, block.Hash)
+		}
+		if err := checkWitness(block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+
+		// Only genesis may have no parent
+		if i == 0 && len(block.PrevBlockHash) != 0 {
+			return fmt.Errorf("block 0: genesis has previous hash %x, want none", block.PrevBlockHash)
+		}
+		if i > 0 && len(block.PrevBlockHash) == 0 {
+			return fmt.Errorf("block %d: missing previous hash", i)
+		}
+		if i > 0 && !bytes.Equal(block.PrevBlockHash, blocks[i-1].Hash) {
+			return fmt.Errorf("block %d: previous hash %x does not match block %d hash %x",
+				i, block.PrevBlockHash, i-1, blocks[i-1].Hash)
+		}
+
+		var parent *Block
+		if i > 0 {
+			parent = blocks[i-1]
+		}
+		if err := bc.checkTimestamp(block, parent); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		// Genesis is exempt from the size limit, as it is elsewhere
+		if i > 0 {
+			if err := bc.checkSize(block); err != nil {
+				return fmt.Errorf("block %d: %w", i, err)
+			}
+		}
+
+		if err := bc.checkCoinbase(block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		if err := bc.checkValidatorReward(block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		if err := bc.checkConsensus(block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+
+		if i == 0 && block.Height != 0 {
+			return fmt.Errorf("block 0: genesis height is %d, want 0", block.Height)
+		}
+		if i > 0 && block.Height != blocks[i-1].Height+1 {
+			return fmt.Errorf("block %d: height %d does not follow block %d height %d",
+				i, block.Height, i-1, blocks[i-1].Height)
+		}
+		if i > 0 {
+			if err := bc.checkDifficulty(block, blocks[:i]); err != nil {
+				return fmt.Errorf("block %d: %w", i, err)
+			}
+		}
+
+		var prevValidatorID []byte
+		if parent != nil {
+			prevValidatorID = parent.ValidatorID
+		}
+		consensus := newConsensusAfter(block.Consensus, block, prevValidatorID, nil)
+		valid, err := consensus.Validate()
+		if err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		if !valid {
+			return fmt.Errorf("block %d: consensus validation failed", i)
+		}
+
+		if _, err := bc.checkTransactions(block.Transactions, block.Height, utxo); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		utxo.Update(block)
+	}
+
+	return nil
+}
+
+// Work returns the expected number of hashes needed to produce the block:
+// 2^TargetBits for PoW, and 1 for mechanisms that involve no hashing work
+func (b *Block) Work() *big.Int {
+	if b.Consensus != POW || b.TargetBits < 0 {
+		return big.NewInt(1)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(b.TargetBits))
+}
+
+// chainWork returns the cumulative work of blocks
+func chainWork(blocks []*Block) *big.Int {
+	total := new(big.Int)
+	for _, block := range blocks {
+		total.Add(total, block.Work())
+	}
+	return total
+}
+
+// TotalDifficulty returns the cumulative work of every block on the chain,
+// which decides between competing forks
+func (bc *Blockchain) TotalDifficulty() *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return chainWork(bc.mustChain())
+}
+
+// ReplaceChain switches to other, ordered from genesis, if it has more total
+// work than the current chain, starts from the same genesis block and is
+// fully valid
+func (bc *Blockchain) ReplaceChain(other []*Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.paused {
+		return ErrChainPaused
+	}
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return err
+	}
+	if len(other) == 0 {
+		return errors.New("candidate chain is empty")
+	}
+	otherWork, work := chainWork(other), chainWork(blocks)
+	if otherWork.Cmp(work) <= 0 {
+		return fmt.Errorf("candidate chain has total difficulty %s, current chain has %s", otherWork, work)
+	}
+	if !bytes.Equal(other[0].Hash, blocks[0].Hash) {
+		return fmt.Errorf("candidate chain starts from genesis %x, want %x", other[0].Hash, blocks[0].Hash)
+	}
+	if err := bc.validateBlocks(other); err != nil {
+		return fmt.Errorf("candidate chain: %w", err)
+	}
+
+	tip := other[len(other)-1]
+	if err := bc.audit("ReplaceChain", fmt.Sprintf("tip %x", tip.Hash)); err != nil {
+		return err
+	}
+
+	// Blocks already in the store are simply overwritten
+	for _, block := range other {
+		if err := bc.store.Put(block); err != nil {
+			return err
+		}
+	}
+	if err := bc.store.SetTip(tip.Hash); err != nil {
+		return err
+	}
+	bc.utxo.Reindex(other)
+
+	bc.logger.Printf("Replaced chain, new tip %d: %x", tip.Height, tip.Hash)
+	bc.events.Publish(Event{Type: ChainReorged, Block: tip, Consensus: bc.consensusAt(tip.Height + 1)})
+	return nil
+}
+
+// VerifyGenesis confirms the chain starts from the expected genesis block,
+// guarding against building on top of the wrong network
+func (bc *Blockchain) VerifyGenesis(expectedHash []byte) error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return err
+	}
+
+	genesis := blocks[0]
+	if !bytes.Equal(genesis.Hash, expectedHash) {
+		return fmt.Errorf("genesis mismatch: have %x, want %x", genesis.Hash, expectedHash)
+	}
+	return nil
+}
+
+// Blocks returns the blocks of the active chain from genesis to tip
+func (bc *Blockchain) Blocks() ([]*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.chain()
+}
+
+// Height returns the height of the tip
+func (bc *Blockchain) Height() (int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	tip, err := bc.store.Tip()
+	if err != nil {
+		return 0, err
+	}
+	block, err := bc.store.Get(tip)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+// GetBlock returns the block with the given hash. Unknown hashes give an
+// error wrapping ErrBlockNotFound.
+func (bc *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.getBlock(hash)
+}
+
+// getBlock is GetBlock for callers holding bc.mu
+func (bc *Blockchain) getBlock(hash []byte) (*Block, error) {
+	if len(hash) == 0 {
+		return nil, fmt.Errorf("%w: empty hash", ErrBlockNotFound)
+	}
+	return bc.store.Get(hash)
+}
+
+// labelPrefix prefixes the store metadata keys holding block labels
+const labelPrefix = "label:"
+
+// Label attaches a human-readable name to the block with the given hash,
+// saving it in the store. Labeling again with the same name moves the label
+// to the new block. Unknown hashes give an error wrapping ErrBlockNotFound.
+func (bc *Blockchain) Label(hash []byte, name string) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if _, err := bc.getBlock(hash); err != nil {
+		return err
+	}
+	return bc.store.PutMeta(labelPrefix+name, hash)
+}
+
+// BlockByLabel returns the block previously bookmarked with Label
+func (bc *Blockchain) BlockByLabel(name string) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	hash, err := bc.store.GetMeta(labelPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, fmt.Errorf("no block labeled %q", name)
+	}
+
+	block, err := bc.getBlock(hash)
+	if err != nil {
+		return nil, fmt.Errorf("label %q: %w", name, err)
+	}
+	return block, nil
+}
+
+// BlocksByValidator returns every block produced by the given miner or validator
+func (bc *Blockchain) BlocksByValidator(addr []byte) []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var blocks []*Block
+	for _, block := range bc.mustChain() {
+		if bytes.Equal(block.ValidatorID, addr) {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// SignalVersionBit sets or clears the given soft-fork bit in the Version of
+// blocks produced from now on
+func (bc *Blockchain) SignalVersionBit(bit int, signal bool) error {
+	if err := checkVersionBit(bit); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if signal {
+		bc.versionBits |= 1 << bit
+	} else {
+		bc.versionBits &^= 1 << bit
+	}
+	return nil
+}
+
+// checkVersionBit rejects a bit that can't carry a soft-fork signal
+func checkVersionBit(bit int) error {
+	if bit < 0 || bit >= versionBitsCount {
+		return fmt.Errorf("version bit %d out of range [0, %d]", bit, versionBitsCount-1)
+	}
+	return nil
+}
+
+// VersionBitsSupport returns the fraction of the last window blocks whose
+// Version signals the given bit, as used for BIP9-style soft-fork activation.
+// Only versions with the BIP9 top bits count as signaling.
+func (bc *Blockchain) VersionBitsSupport(bit int, window int) (float64, error) {
+	if err := checkVersionBit(bit); err != nil {
+		return 0, err
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return 0, err
+	}
+	if window > len(blocks) {
+		window = len(blocks)
+	}
+	if window <= 0 {
+		return 0, nil
+	}
+
+	var signaling int
+	for _, block := range blocks[len(blocks)-window:] {
+		version := uint32(block.Version)
+		if version&versionBitsTopMask == versionBitsTopBits && version&(1<<bit) != 0 {
+			signaling++
+		}
+	}
+	return float64(signaling) / float64(window), nil
+}
+
+// ValidationTimings returns the average time Validate takes for the blocks
+// of each consensus type present in the chain. Blocks failing validation
+// are left out, as a failure can return before doing the full work.
+func (bc *Blockchain) ValidationTimings() map[ConsensusType]time.Duration {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	totals := make(map[ConsensusType]time.Duration)
+	counts := make(map[ConsensusType]int)
+
+	blocks := bc.mustChain()
+	for i, block := range blocks {
+		var prevValidatorID []byte
+		if i > 0 {
+			prevValidatorID = blocks[i-1].ValidatorID
+		}
+		consensus := newConsensusAfter(block.Consensus, block, prevValidatorID, nil)
+
+		start := time.Now()
+		valid, err := consensus.Validate()
+		elapsed := time.Since(start)
+		if !valid || err != nil {
+			continue
+		}
+		totals[block.Consensus] += elapsed
+		counts[block.Consensus]++
+	}
+
+	timings := make(map[ConsensusType]time.Duration, len(totals))
+	for consensusType, total := range totals {
+		timings[consensusType] = total / time.Duration(counts[consensusType])
+	}
+	return timings
 }
 
 func main() {
-	// Create new blockchain with PoW
-	fmt.Println("Creating blockchain with Proof of Work...")
-	bc := NewBlockchain(POW)
-
-	fmt.Println("Mining block 1 with PoW...")
-	bc.AddBlock("Send 50 BTC to John")
-
-	// Switch to PoS
-	fmt.Println("\nSwitching to Proof of Stake...")
-	bc.SwitchConsensus(POS)
-
-	fmt.Println("Creating block 2 with PoS...")
-	bc.AddBlock("Send 30 BTC to Jane")
-
-	// Print all blocks in the blockchain
-	for i, block := range bc.blocks {
-		fmt.Printf("\nBlock %d:\n", i)
-		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
-		fmt.Printf("Data: %s\n", block.Data)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		fmt.Printf("Validator ID: %s\n", block.ValidatorID)
-
-		// Validate the block
-		consensus := NewConsensus(bc.consensusType, block)
-		fmt.Printf("Valid: %s\n", strconv.FormatBool(consensus.Validate()))
+	cli := NewCLI(defaultDBPath, os.Stdout)
+	if err := cli.Run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
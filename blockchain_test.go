@@ -0,0 +1,1266 @@
+package main
+
+import (
+	"bytes"         // for comparing hashes
+	"context"       // for mining test blocks
+	"encoding/hex"  // for UTXO transaction keys
+	"errors"        // for matching sentinel errors
+	"fmt"           // for block data
+	"path/filepath" // for database paths
+	"reflect"       // for comparing decoded blocks and outputs
+	"strings"       // for matching error messages
+	"testing"       // for the test harness
+	"time"          // for block timestamps
+)
+
+// testTargetBits keeps PoW tests fast while still requiring some work
+const testTargetBits = 8
+
+// newTestChain creates an in-memory chain using consensusType
+func newTestChain(t testing.TB, consensusType ConsensusType) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchainWithStore(NewMemoryStore(), consensusType, testTargetBits)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithStore: %v", err)
+	}
+	return bc
+}
+
+// forkTestChain creates an in-memory chain sharing bc's genesis block, as a
+// peer on the same network would
+func forkTestChain(t testing.TB, bc *Blockchain) *Blockchain {
+	t.Helper()
+	genesis := bc.mustChain()[0]
+
+	store := NewMemoryStore()
+	if err := store.Put(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTip(genesis.Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := NewBlockchainWithStore(store, genesis.Consensus, testTargetBits)
+	if err != nil {
+		t.Fatalf("NewBlockchainWithStore: %v", err)
+	}
+	return fork
+}
+
+// addTestBlocks adds n blocks carrying no transactions
+func addTestBlocks(t testing.TB, bc *Blockchain, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := bc.AddBlock(nil); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+	}
+}
+
+// tipBlock returns the block at the tip of bc
+func tipBlock(t testing.TB, bc *Blockchain) *Block {
+	t.Helper()
+	blocks, err := bc.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks[len(blocks)-1]
+}
+
+// nextTestDifficulty returns the target bits for bc's next block
+func nextTestDifficulty(t testing.TB, bc *Blockchain) int {
+	t.Helper()
+	bits, err := bc.nextDifficulty()
+	if err != nil {
+		t.Fatalf("nextDifficulty: %v", err)
+	}
+	return bits
+}
+
+// forgeAuthorityBlock builds a PoA block on bc's tip without doing any work,
+// as a peer trying to bypass the chain's consensus would
+func forgeAuthorityBlock(t *testing.T, bc *Blockchain) *Block {
+	t.Helper()
+	tip := tipBlock(t, bc)
+	block := &Block{
+		Version:       BlockVersion,
+		Timestamp:     time.Now().Unix(),
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 1,
+		ValidatorID:   []byte("authority2"),
+		TargetBits:    nextTestDifficulty(t, bc),
+		Consensus:     POA,
+	}
+	block.Hash = block.ComputeHash()
+	return block
+}
+
+// forceTip appends block to bc's store without validating it
+func forceTip(t *testing.T, bc *Blockchain, block *Block) {
+	t.Helper()
+	if err := bc.store.Put(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.store.SetTip(block.Hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptBlockRejectsOtherConsensus(t *testing.T) {
+	bc := newTestChain(t, POW)
+
+	err := bc.AcceptBlock(forgeAuthorityBlock(t, bc))
+	if err == nil || !strings.Contains(err.Error(), "PoW is in force") {
+		t.Fatalf("AcceptBlock of a PoA block on a PoW chain = %v, want consensus error", err)
+	}
+}
+
+func TestValidateRejectsOtherConsensus(t *testing.T) {
+	bc := newTestChain(t, POW)
+	forceTip(t, bc, forgeAuthorityBlock(t, bc))
+
+	err := bc.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "block 1:") {
+		t.Fatalf("Validate = %v, want error at block 1", err)
+	}
+}
+
+func TestHashCoversConsensus(t *testing.T) {
+	bc := newTestChain(t, POW)
+	addTestBlocks(t, bc, 1)
+
+	block := *tipBlock(t, bc)
+	block.Consensus = POA
+	if string(block.ComputeHash()) == string(block.Hash) {
+		t.Fatal("changing Consensus did not change the block hash")
+	}
+}
+
+func TestReplaceChainRejectsOtherConsensus(t *testing.T) {
+	bc := newTestChain(t, POW)
+	fork := forkTestChain(t, bc)
+
+	// Two PoA blocks carry more work than the bare genesis chain
+	for i := 0; i < 2; i++ {
+		forceTip(t, fork, forgeAuthorityBlock(t, fork))
+	}
+
+	err := bc.ReplaceChain(fork.mustChain())
+	if err == nil || !strings.Contains(err.Error(), "block 1:") {
+		t.Fatalf("ReplaceChain = %v, want error at block 1", err)
+	}
+}
+
+func TestSwitchConsensusIsEnforcedAndSaved(t *testing.T) {
+	store := NewMemoryStore()
+	bc, err := NewBlockchainWithStore(store, POW, testTargetBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestBlocks(t, bc, 1)
+	if err := bc.SwitchConsensus(POA); err != nil {
+		t.Fatalf("SwitchConsensus: %v", err)
+	}
+	addTestBlocks(t, bc, 2)
+
+	if got := tipBlock(t, bc).Consensus; got != POA {
+		t.Fatalf("tip consensus = %s, want PoA", got)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	// Reopening the store keeps the switch, whatever consensus is passed
+	reopened, err := NewBlockchainWithStore(store, POW, testTargetBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Validate(); err != nil {
+		t.Fatalf("Validate after reopening: %v", err)
+	}
+	addTestBlocks(t, reopened, 1)
+	if got := tipBlock(t, reopened).Consensus; got != POA {
+		t.Fatalf("tip consensus after reopening = %s, want PoA", got)
+	}
+}
+
+func TestConsensusLockHeight(t *testing.T) {
+	bc := newTestChain(t, POW)
+	bc.SetConsensusLockHeight(2)
+
+	if err := bc.SwitchConsensus(POA); err != nil {
+		t.Fatalf("SwitchConsensus below the lock height: %v", err)
+	}
+	addTestBlocks(t, bc, 2)
+
+	err := bc.SwitchConsensus(POW)
+	if err == nil || !strings.Contains(err.Error(), "locked since height 2") {
+		t.Fatalf("SwitchConsensus at the lock height = %v, want locked error", err)
+	}
+	addTestBlocks(t, bc, 1)
+	if got := tipBlock(t, bc).Consensus; got != POA {
+		t.Fatalf("tip consensus after a refused switch = %s, want PoA", got)
+	}
+
+	// Removing the lock allows switching again
+	bc.SetConsensusLockHeight(0)
+	if err := bc.SwitchConsensus(POW); err != nil {
+		t.Fatalf("SwitchConsensus after unlocking: %v", err)
+	}
+}
+
+// mineTestBlock mines a PoW block holding txs on bc's tip at the given
+// difficulty, without checking it against the chain
+func mineTestBlock(t *testing.T, bc *Blockchain, targetBits int, txs ...*Transaction) *Block {
+	t.Helper()
+	tip := tipBlock(t, bc)
+	block, err := forgeBlock(context.Background(), txs, tip.Hash, tip.Height+1, time.Now().Unix(), tip.ValidatorID, POW, targetBits, nil)
+	if err != nil {
+		t.Fatalf("forgeBlock: %v", err)
+	}
+	return block
+}
+
+func TestAcceptBlockRejectsWrongTargetBits(t *testing.T) {
+	bc := newTestChain(t, POW)
+
+	err := bc.AcceptBlock(mineTestBlock(t, bc, 0))
+	if err == nil || !strings.Contains(err.Error(), "target bits 0") {
+		t.Fatalf("AcceptBlock of a zero-difficulty block = %v, want target bits error", err)
+	}
+
+	if err := bc.AcceptBlock(mineTestBlock(t, bc, nextTestDifficulty(t, bc))); err != nil {
+		t.Fatalf("AcceptBlock at the expected difficulty: %v", err)
+	}
+}
+
+func TestValidateRejectsWrongTargetBits(t *testing.T) {
+	bc := newTestChain(t, POW)
+	forceTip(t, bc, mineTestBlock(t, bc, 0))
+
+	err := bc.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "block 1: target bits") {
+		t.Fatalf("Validate = %v, want target bits error at block 1", err)
+	}
+}
+
+func TestReplaceChainRejectsWrongTargetBits(t *testing.T) {
+	bc := newTestChain(t, POW)
+	fork := forkTestChain(t, bc)
+
+	// Enough zero-difficulty blocks to outweigh the genesis block
+	for i := 0; i <= 1<<testTargetBits; i++ {
+		forceTip(t, fork, mineTestBlock(t, fork, 0))
+	}
+
+	err := bc.ReplaceChain(fork.mustChain())
+	if err == nil || !strings.Contains(err.Error(), "block 1: target bits") {
+		t.Fatalf("ReplaceChain = %v, want target bits error at block 1", err)
+	}
+}
+
+// newTestWallet creates a wallet with a fresh key
+func newTestWallet(t *testing.T) *Wallet {
+	t.Helper()
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatalf("NewWallet: %v", err)
+	}
+	return w
+}
+
+// fundedTestChain creates a PoW chain paying block rewards to a new wallet,
+// with one block after genesis. It returns the chain, the wallet and the
+// coinbase paying it.
+func fundedTestChain(t *testing.T) (*Blockchain, *Wallet, *Transaction) {
+	t.Helper()
+	bc := newTestChain(t, POW)
+	w := newTestWallet(t)
+	bc.SetRewardAddress(w.Address())
+	addTestBlocks(t, bc, 1)
+	return bc, w, tipBlock(t, bc).Transactions[0]
+}
+
+// spendTx creates a transaction signed by from, spending output vout of prev
+// into outs
+func spendTx(t *testing.T, bc *Blockchain, from *Wallet, prev *Transaction, vout int, outs ...TXOutput) *Transaction {
+	t.Helper()
+	tx := &Transaction{Vin: []TXInput{{Txid: prev.ID, Vout: vout}}, Vout: outs}
+	if err := bc.SignTransaction(tx, *from.PrivateKey); err != nil {
+		t.Fatalf("SignTransaction: %v", err)
+	}
+	return tx
+}
+
+func TestAddBlockAcceptsValidSpend(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	to := newTestWallet(t)
+
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 30, Address: to.Address()}, TXOutput{Value: 20, Address: w.Address()})
+	if err := bc.AddBlock([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := bc.UTXOSet().Balance(to.Address()); got != 30 {
+		t.Fatalf("recipient balance = %d, want 30", got)
+	}
+}
+
+func TestUTXOSetAfterCoinbaseSpent(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	to := newTestWallet(t)
+	// Pay later rewards elsewhere so only the spend affects w
+	bc.SetRewardAddress(newTestWallet(t).Address())
+	reward := coinbase.Vout[0].Value
+
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 30, Address: to.Address()}, TXOutput{Value: reward - 30, Address: w.Address()})
+	if err := bc.AddBlock([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	utxo := bc.UTXOSet()
+	if got := utxo.Balance(w.Address()); got != reward-30 {
+		t.Errorf("sender balance = %d, want %d", got, reward-30)
+	}
+	if got := utxo.Balance(to.Address()); got != 30 {
+		t.Errorf("recipient balance = %d, want 30", got)
+	}
+
+	// The spent coinbase is gone, leaving only the change
+	total, outs := utxo.FindSpendableOutputs(w.Address(), reward)
+	wantOuts := map[string][]int{hex.EncodeToString(tx.ID): {1}}
+	if total != reward-30 || !reflect.DeepEqual(outs, wantOuts) {
+		t.Errorf("sender spendable = %d, %v, want %d, %v", total, outs, reward-30, wantOuts)
+	}
+	total, outs = utxo.FindSpendableOutputs(to.Address(), 10)
+	wantOuts = map[string][]int{hex.EncodeToString(tx.ID): {0}}
+	if total != 30 || !reflect.DeepEqual(outs, wantOuts) {
+		t.Errorf("recipient spendable = %d, %v, want 30, %v", total, outs, wantOuts)
+	}
+}
+
+func TestAddBlockRejectsDoubleSpend(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	a := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+	b := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+
+	// Within one block...
+	err := bc.AddBlock([]*Transaction{a, b})
+	if err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Fatalf("AddBlock spending an output twice = %v, want already spent error", err)
+	}
+
+	// ...and across blocks
+	if err := bc.AddBlock([]*Transaction{a}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	err = bc.AddBlock([]*Transaction{b})
+	if err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Fatalf("AddBlock spending a spent output = %v, want already spent error", err)
+	}
+}
+
+func TestAddBlockRejectsOverspend(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 1000, Address: w.Address()})
+	err := bc.AddBlock([]*Transaction{tx})
+	if err == nil || !strings.Contains(err.Error(), "creates 950 more than it spends") {
+		t.Fatalf("AddBlock of an overspend = %v, want overspend error", err)
+	}
+
+	// Negative outputs can't be used to balance the books
+	tx = spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 1000, Address: w.Address()}, TXOutput{Value: -950, Address: w.Address()})
+	err = bc.AddBlock([]*Transaction{tx})
+	if err == nil || !strings.Contains(err.Error(), "negative value") {
+		t.Fatalf("AddBlock with a negative output = %v, want negative value error", err)
+	}
+}
+
+func TestAddBlockRejectsMint(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	mint := NewTransaction(nil, []TXOutput{{Value: 1 << 40, Address: w.Address()}})
+
+	// After another transaction, an input-less one isn't a coinbase...
+	spend := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: w.Address()})
+	err := bc.AddBlock([]*Transaction{spend, mint})
+	if err == nil || !strings.Contains(err.Error(), "not the coinbase") {
+		t.Fatalf("AddBlock with a mint after a spend = %v, want not the coinbase error", err)
+	}
+
+	// ...and as the coinbase it can't pay more than the reward
+	bc.SetRewardAddress(nil)
+	err = bc.AddBlock([]*Transaction{mint})
+	if err == nil || !strings.Contains(err.Error(), "more than the reward") {
+		t.Fatalf("AddBlock with a minting coinbase = %v, want reward error", err)
+	}
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: w.Address()})
+	if valid, err := bc.VerifyTransaction(tx); !valid || err != nil {
+		t.Fatalf("VerifyTransaction of a valid spend = %t, %v", valid, err)
+	}
+
+	mint := NewTransaction(nil, []TXOutput{{Value: 1 << 40, Address: w.Address()}})
+	if valid, err := bc.VerifyTransaction(mint); valid || err == nil {
+		t.Fatalf("VerifyTransaction of an input-less transaction = %t, %v, want an error", valid, err)
+	}
+}
+
+func TestReceivedBlocksRejectDoubleSpend(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	a := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+	b := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+	block := mineTestBlock(t, bc, nextTestDifficulty(t, bc), a, b)
+
+	err := bc.AcceptBlock(block)
+	if err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Fatalf("AcceptBlock spending an output twice = %v, want already spent error", err)
+	}
+
+	forceTip(t, bc, block)
+	err = bc.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "block 2:") {
+		t.Fatalf("Validate = %v, want error at block 2", err)
+	}
+}
+
+func TestValidationTimingsCoversMixedChain(t *testing.T) {
+	bc := newTestChain(t, POW)
+	addTestBlocks(t, bc, 3)
+	if err := bc.SwitchConsensus(POS); err != nil {
+		t.Fatalf("SwitchConsensus: %v", err)
+	}
+	addTestBlocks(t, bc, 3)
+
+	timings := bc.ValidationTimings()
+	for _, consensusType := range []ConsensusType{POW, POS} {
+		if timings[consensusType] <= 0 {
+			t.Errorf("ValidationTimings()[%s] = %v, want a positive average", consensusType, timings[consensusType])
+		}
+	}
+	if len(timings) != 2 {
+		t.Errorf("ValidationTimings() = %v, want only PoW and PoS", timings)
+	}
+}
+
+func TestValidationTimingsCountsOnlyValidBlocks(t *testing.T) {
+	bc := newTestChain(t, POS)
+	addTestBlocks(t, bc, 10)
+
+	// authority2 is out of turn at height 11, so the PoA block fails
+	// validation and has no timing
+	forceTip(t, bc, forgeAuthorityBlock(t, bc))
+	if timing, ok := bc.ValidationTimings()[POA]; ok {
+		t.Fatalf("ValidationTimings() includes %v for an invalid PoA block", timing)
+	}
+}
+
+func TestLabelsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	bc, err := NewBlockchain(path, POW, testTargetBits)
+	if err != nil {
+		t.Fatalf("NewBlockchain: %v", err)
+	}
+	addTestBlocks(t, bc, 1)
+	tip := tipBlock(t, bc)
+
+	if err := bc.Label(tip.Hash, "checkpoint-1"); err != nil {
+		t.Fatalf("Label: %v", err)
+	}
+	if err := bc.Label([]byte("missing"), "nowhere"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("Label of an unknown hash = %v, want ErrBlockNotFound", err)
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err = NewBlockchain(path, POW, testTargetBits)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bc.Close()
+
+	block, err := bc.BlockByLabel("checkpoint-1")
+	if err != nil {
+		t.Fatalf("BlockByLabel after reopening: %v", err)
+	}
+	if !bytes.Equal(block.Hash, tip.Hash) {
+		t.Fatalf("BlockByLabel = %x, want %x", block.Hash, tip.Hash)
+	}
+	if _, err := bc.BlockByLabel("nowhere"); err == nil {
+		t.Fatal("BlockByLabel found a label on an unknown hash")
+	}
+}
+
+func TestValidateRejectsOversizedBlocks(t *testing.T) {
+	bc := newTestChain(t, POW)
+	other := forkTestChain(t, bc)
+
+	// A block too large for a chain with a smaller limit
+	tx := &Transaction{Data: bytes.Repeat([]byte("x"), 4096)}
+	tx.ID = tx.Hash()
+	if err := other.AddBlock([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	addTestBlocks(t, other, 1)
+	blocks, err := other.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc.SetMaxBlockSize(1024)
+	err = bc.ReplaceChain(blocks)
+	if err == nil || !strings.Contains(err.Error(), "limit is 1024") {
+		t.Fatalf("ReplaceChain with an oversized block = %v, want size error", err)
+	}
+
+	other.SetMaxBlockSize(1024)
+	if err := other.Validate(); err == nil || !strings.Contains(err.Error(), "block 1") {
+		t.Fatalf("Validate with an oversized block = %v, want block 1 size error", err)
+	}
+}
+
+// dataTx returns a transaction carrying data and no coins
+func dataTx(data string) *Transaction {
+	tx := &Transaction{Data: []byte(data)}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestBlockSerializeRoundTrip(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+	block := mineTestBlock(t, bc, nextTestDifficulty(t, bc), tx, dataTx("payload"))
+
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	decoded, err := DeserializeBlock(data)
+	if err != nil {
+		t.Fatalf("DeserializeBlock: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, block) {
+		t.Fatalf("DeserializeBlock = %+v, want %+v", decoded, block)
+	}
+	if _, err := DeserializeBlock(data[:len(data)/2]); err == nil {
+		t.Fatal("DeserializeBlock accepted truncated data")
+	}
+}
+
+func TestValidateReportsTamperedBlock(t *testing.T) {
+	for _, index := range []int{1, 2, 3} {
+		bc := newTestChain(t, POW)
+		for i := 1; i <= 3; i++ {
+			if err := bc.AddBlock([]*Transaction{dataTx(fmt.Sprintf("block %d", i))}); err != nil {
+				t.Fatalf("AddBlock: %v", err)
+			}
+		}
+		if err := bc.Validate(); err != nil {
+			t.Fatalf("Validate before tampering: %v", err)
+		}
+
+		// Replace the stored block with a copy carrying different data
+		blocks, err := bc.Blocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered := *blocks[index]
+		tampered.Transactions = []*Transaction{{ID: tampered.Transactions[0].ID, Data: []byte("forged")}}
+		if err := bc.store.Put(&tampered); err != nil {
+			t.Fatal(err)
+		}
+
+		err = bc.Validate()
+		want := fmt.Sprintf("block %d:", index)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("Validate with block %d tampered = %v, want error starting %q", index, err, want)
+		}
+	}
+}
+
+func BenchmarkChainValidate(b *testing.B) {
+	bc := newTestChain(b, POW)
+	for i := 0; i < 10; i++ {
+		if err := bc.AddBlock([]*Transaction{dataTx(fmt.Sprintf("block %d", i))}); err != nil {
+			b.Fatalf("AddBlock: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := bc.Validate(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWitnessIsCommitted(t *testing.T) {
+	bc := newTestChain(t, POW)
+	tip := tipBlock(t, bc)
+	block := &Block{
+		Version:       BlockVersion,
+		Timestamp:     time.Now().Unix(),
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 1,
+		TargetBits:    nextTestDifficulty(t, bc),
+		Consensus:     POW,
+	}
+	block.SetWitness([]byte("extended signatures"))
+	nonce, hash, err := NewProofOfWork(block).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	block.ValidatorID, block.Hash = nonce, hash
+
+	// Swapping the witness leaves the main hash alone but not WitnessHash
+	swapped := *block
+	swapped.Witness = []byte("attacker bytes")
+	if !bytes.Equal(swapped.ComputeHash(), block.Hash) {
+		t.Fatal("changing the witness changed the block hash")
+	}
+	if bytes.Equal(swapped.WitnessHash(), block.WitnessHash()) {
+		t.Fatal("changing the witness did not change WitnessHash")
+	}
+	// ...and the commitment can't follow it without changing the hash
+	recommitted := swapped
+	recommitted.SetWitness(swapped.Witness)
+	if bytes.Equal(recommitted.ComputeHash(), block.Hash) {
+		t.Fatal("changing the witness commitment did not change the block hash")
+	}
+
+	err = bc.AcceptBlock(&swapped)
+	if err == nil || !strings.Contains(err.Error(), "does not match commitment") {
+		t.Fatalf("AcceptBlock with a swapped witness = %v, want commitment error", err)
+	}
+	if err := bc.AcceptBlock(block); err != nil {
+		t.Fatalf("AcceptBlock: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	forceTip(t, bc, &swapped)
+	err = bc.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "block 1: witness") {
+		t.Fatalf("Validate with a swapped witness = %v, want witness error at block 1", err)
+	}
+}
+
+func TestBlocksByValidator(t *testing.T) {
+	bc := newTestChain(t, POS)
+	addTestBlocks(t, bc, 8)
+
+	blocks, err := bc.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, block := range blocks {
+		counts[string(block.ValidatorID)]++
+	}
+	if len(counts) < 2 {
+		t.Fatalf("8 PoS blocks were all forged by %v, want several validators", counts)
+	}
+
+	for validator, count := range counts {
+		forged := bc.BlocksByValidator([]byte(validator))
+		if len(forged) != count {
+			t.Errorf("BlocksByValidator(%s) returned %d blocks, want %d", validator, len(forged), count)
+		}
+		for _, block := range forged {
+			if string(block.ValidatorID) != validator {
+				t.Errorf("BlocksByValidator(%s) returned block %d forged by %s", validator, block.Height, block.ValidatorID)
+			}
+		}
+	}
+	if forged := bc.BlocksByValidator([]byte("nobody")); len(forged) != 0 {
+		t.Fatalf("BlocksByValidator of an unknown validator returned %d blocks", len(forged))
+	}
+}
+
+func TestSetPaused(t *testing.T) {
+	bc := newTestChain(t, POW)
+	bc.SetPaused(true)
+
+	if err := bc.AddBlock(nil); !errors.Is(err, ErrChainPaused) {
+		t.Fatalf("AddBlock while paused = %v, want ErrChainPaused", err)
+	}
+	// Reads still work while paused
+	if height, err := bc.Height(); err != nil || height != 0 {
+		t.Fatalf("Height while paused = %d, %v, want 0", height, err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate while paused: %v", err)
+	}
+
+	bc.SetPaused(false)
+	addTestBlocks(t, bc, 1)
+	if height, err := bc.Height(); err != nil || height != 1 {
+		t.Fatalf("Height after resuming = %d, %v, want 1", height, err)
+	}
+}
+
+func TestVersionBitsSupport(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 2)
+	if err := bc.SignalVersionBit(3, true); err != nil {
+		t.Fatalf("SignalVersionBit: %v", err)
+	}
+	addTestBlocks(t, bc, 3)
+	if err := bc.SignalVersionBit(3, false); err != nil {
+		t.Fatalf("SignalVersionBit: %v", err)
+	}
+	addTestBlocks(t, bc, 1)
+
+	tests := []struct {
+		bit, window int
+		want        float64
+	}{
+		{3, 4, 0.75},      // three of the last four blocks signal
+		{3, 1, 0},         // the tip stopped signaling
+		{3, 100, 3.0 / 7}, // the window is capped at the chain's length
+		{0, 7, 0},         // bits nobody signals
+		{3, 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := bc.VersionBitsSupport(tt.bit, tt.window)
+		if err != nil || got != tt.want {
+			t.Errorf("VersionBitsSupport(%d, %d) = %v, %v, want %v", tt.bit, tt.window, got, err, tt.want)
+		}
+	}
+
+	for _, bit := range []int{-1, versionBitsCount, 31} {
+		if _, err := bc.VersionBitsSupport(bit, 4); err == nil {
+			t.Errorf("VersionBitsSupport(%d, 4) succeeded, want range error", bit)
+		}
+		if err := bc.SignalVersionBit(bit, true); err == nil {
+			t.Errorf("SignalVersionBit(%d) succeeded, want range error", bit)
+		}
+	}
+
+	// A version without the BIP9 top bits signals nothing
+	block := forgeAuthorityBlock(t, bc)
+	block.Version = 1 << 3
+	forceTip(t, bc, block)
+	if got, err := bc.VersionBitsSupport(3, 1); err != nil || got != 0 {
+		t.Errorf("VersionBitsSupport of a legacy version = %v, %v, want 0", got, err)
+	}
+}
+
+func TestVerifyGenesis(t *testing.T) {
+	bc := newTestChain(t, POW)
+	genesis := bc.mustChain()[0]
+	addTestBlocks(t, bc, 1)
+
+	if err := bc.VerifyGenesis(genesis.Hash); err != nil {
+		t.Fatalf("VerifyGenesis with the genesis hash: %v", err)
+	}
+	other, err := NewBlockchainWithStore(NewMemoryStore(), POW, testTargetBits, GenesisConfig{Data: "another network"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bc.VerifyGenesis(other.mustChain()[0].Hash)
+	if err == nil || !strings.Contains(err.Error(), "genesis mismatch") {
+		t.Fatalf("VerifyGenesis with another chain's genesis = %v, want mismatch error", err)
+	}
+	if err := bc.VerifyGenesis(tipBlock(t, bc).Hash); err == nil {
+		t.Fatal("VerifyGenesis accepted the hash of a later block")
+	}
+}
+
+func TestProcessQuarantineConnectsOutOfOrderBlocks(t *testing.T) {
+	source := newTestChain(t, POW)
+	bc := forkTestChain(t, source)
+	addTestBlocks(t, source, 3)
+	blocks, err := source.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A block building on the source tip that breaks the consensus rules
+	invalid := forgeAuthorityBlock(t, source)
+
+	for _, block := range []*Block{blocks[3], invalid, blocks[1], blocks[2]} {
+		bc.Quarantine(block)
+	}
+	if height := tipBlock(t, bc).Height; height != 0 {
+		t.Fatalf("height after quarantining = %d, want 0 until processed", height)
+	}
+
+	if err := bc.ProcessQuarantine(); err != nil {
+		t.Fatalf("ProcessQuarantine: %v", err)
+	}
+	if tip := tipBlock(t, bc); !bytes.Equal(tip.Hash, blocks[3].Hash) {
+		t.Fatalf("tip after processing = block %d %x, want block 3 %x", tip.Height, tip.Hash, blocks[3].Hash)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if len(bc.quarantine) != 0 {
+		t.Fatalf("%d blocks left in quarantine, want the invalid one discarded", len(bc.quarantine))
+	}
+}
+
+func TestComputeHashCoversEveryField(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: w.Address()})
+	block := mineTestBlock(t, bc, nextTestDifficulty(t, bc), tx)
+
+	tests := []struct {
+		field  string
+		mutate func(b *Block)
+	}{
+		{"Version", func(b *Block) { b.Version++ }},
+		{"Timestamp", func(b *Block) { b.Timestamp++ }},
+		{"Transactions", func(b *Block) { b.Transactions = []*Transaction{dataTx("other")} }},
+		{"PrevBlockHash", func(b *Block) { b.PrevBlockHash = []byte("other parent") }},
+		{"Height", func(b *Block) { b.Height++ }},
+		{"ValidatorID", func(b *Block) { b.ValidatorID = IntToHex(42) }},
+		{"TargetBits", func(b *Block) { b.TargetBits++ }},
+		{"Consensus", func(b *Block) { b.Consensus = POS }},
+		{"WitnessCommitment", func(b *Block) { b.SetWitness([]byte("witness")) }},
+		{"ValidatorStake", func(b *Block) { b.ValidatorStake++ }},
+		{"TotalStake", func(b *Block) { b.TotalStake++ }},
+	}
+	for _, tt := range tests {
+		mutated := *block
+		tt.mutate(&mutated)
+		if bytes.Equal(mutated.ComputeHash(), block.Hash) {
+			t.Errorf("changing %s did not change the block hash", tt.field)
+		}
+	}
+
+	if !bytes.Equal(block.ComputeHash(), block.Hash) {
+		t.Fatal("ComputeHash of the unchanged block differs from its Hash")
+	}
+}
+
+// timedBlocks returns n blocks of the given consensus and difficulty,
+// interval seconds apart, starting at height 0
+func timedBlocks(consensusType ConsensusType, bits int, interval int64, n int) []*Block {
+	blocks := make([]*Block, n)
+	for i := range blocks {
+		blocks[i] = &Block{Height: i, Timestamp: int64(i) * interval, TargetBits: bits, Consensus: consensusType}
+	}
+	return blocks
+}
+
+func TestDifficultyAdjustment(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocks   []*Block
+		minBits  int
+		wantBits int
+	}{
+		{"fast blocks raise difficulty", timedBlocks(POW, 10, 1, 12), 0, 11},
+		{"slow blocks lower difficulty", timedBlocks(POW, 10, 60, 12), 0, 9},
+		{"on-target blocks keep difficulty", timedBlocks(POW, 10, targetBlockTime, 12), 0, 10},
+		{"genesis alone keeps difficulty", timedBlocks(POW, 10, 1, 1), 0, 10},
+		{"slow blocks stop at the floor", timedBlocks(POW, 10, 60, 12), 10, 10},
+		{"the floor applies immediately", timedBlocks(POW, 4, targetBlockTime, 12), 6, 6},
+		{"fast blocks stop at the ceiling", timedBlocks(POW, maxTargetBits, 1, 12), 0, maxTargetBits},
+		{"PoS intervals are ignored", append(timedBlocks(POW, 10, targetBlockTime, 1), timedBlocks(POS, 10, 1, 11)[1:]...), 0, 10},
+	}
+	for _, tt := range tests {
+		bc := newTestChain(t, POW)
+		bc.SetMinTargetBits(tt.minBits)
+		if got := bc.difficultyAfter(tt.blocks); got != tt.wantBits {
+			t.Errorf("%s: difficultyAfter = %d, want %d", tt.name, got, tt.wantBits)
+		}
+	}
+
+	// Blocks that aren't mined carry the difficulty unchanged
+	bc := newTestChain(t, POW)
+	if err := bc.SwitchConsensus(POS); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.difficultyAfter(timedBlocks(POW, 10, 1, 12)); got != 10 {
+		t.Errorf("difficultyAfter for a PoS block = %d, want 10", got)
+	}
+}
+
+func TestDifficultySurvivesConsensusSwitch(t *testing.T) {
+	bc := newTestChain(t, POW)
+	if err := bc.SwitchConsensus(POS); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quick PoS blocks must not drive up the difficulty
+	addTestBlocks(t, bc, 30)
+	if bits := tipBlock(t, bc).TargetBits; bits != testTargetBits {
+		t.Fatalf("target bits after 30 PoS blocks = %d, want %d", bits, testTargetBits)
+	}
+
+	if err := bc.SwitchConsensus(POW); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlocks(t, bc, 1)
+	if bits := tipBlock(t, bc).TargetBits; bits != testTargetBits {
+		t.Fatalf("target bits of the first PoW block after PoS = %d, want %d", bits, testTargetBits)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestBlockHashCoversEveryTransaction(t *testing.T) {
+	// threeTxs returns a fresh set of transactions, so mutating one set
+	// leaves the others untouched
+	threeTxs := func() []*Transaction {
+		return []*Transaction{
+			{Vout: []TXOutput{{Value: 50, Address: []byte("miner")}}},
+			{Vin: []TXInput{{Txid: []byte("prev"), Vout: 0}}, Vout: []TXOutput{{Value: 20, Address: []byte("alice")}}},
+			{Data: []byte("note")},
+		}
+	}
+	hashOf := func(txs []*Transaction) []byte {
+		return unsealedBlock(txs, []byte("parent"), 1, 1700000000, POA, testTargetBits).ComputeHash()
+	}
+	base := hashOf(threeTxs())
+
+	mutations := map[string]func(tx *Transaction){
+		"data":         func(tx *Transaction) { tx.Data = append(tx.Data, 'x') },
+		"output value": func(tx *Transaction) { tx.Vout = append(tx.Vout, TXOutput{Value: 1}) },
+		"input":        func(tx *Transaction) { tx.Vin = append(tx.Vin, TXInput{Txid: []byte("other"), Vout: 1}) },
+	}
+	for i := 0; i < 3; i++ {
+		for name, mutate := range mutations {
+			txs := threeTxs()
+			mutate(txs[i])
+			if bytes.Equal(hashOf(txs), base) {
+				t.Errorf("changing the %s of transaction %d left the block hash unchanged", name, i)
+			}
+		}
+	}
+
+	// Reordering the transactions changes the hash too
+	txs := threeTxs()
+	txs[1], txs[2] = txs[2], txs[1]
+	if bytes.Equal(hashOf(txs), base) {
+		t.Error("reordering transactions left the block hash unchanged")
+	}
+}
+
+func TestBlockString(t *testing.T) {
+	block := &Block{
+		Version:      BlockVersion,
+		Timestamp:    1700000000,
+		Transactions: []*Transaction{dataTx("hello, world"), {Vout: []TXOutput{{Value: 50, Address: []byte("miner")}}}},
+		Height:       3,
+		ValidatorID:  []byte("authority1"),
+		Consensus:    POA,
+	}
+	block.Hash = block.ComputeHash()
+
+	out := block.String()
+	for _, want := range []string{
+		"Height: 3\n",
+		fmt.Sprintf("Hash: %x", block.Hash[:4]),
+		"Timestamp: 2023-11-14T22:13:20Z\n",
+		"Consensus: PoA\n",
+		"Validator ID: authority1\n",
+		"Transactions: 2",
+		`Data: "hello, world"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("String() lacks %q:\n%s", want, out)
+		}
+	}
+	// Transactions without data print only their ID
+	if n := strings.Count(out, "Data:"); n != 1 {
+		t.Errorf("String() prints %d data lines, want 1:\n%s", n, out)
+	}
+}
+
+func TestGetBlock(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 2)
+
+	for _, want := range bc.mustChain() {
+		got, err := bc.GetBlock(want.Hash)
+		if err != nil {
+			t.Fatalf("GetBlock(%x): %v", want.Hash, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("GetBlock(%x) returned height %d, want %d", want.Hash, got.Height, want.Height)
+		}
+	}
+
+	for _, hash := range [][]byte{[]byte("missing"), nil, {}} {
+		block, err := bc.GetBlock(hash)
+		if !errors.Is(err, ErrBlockNotFound) || block != nil {
+			t.Errorf("GetBlock(%q) = %v, %v, want ErrBlockNotFound", hash, block, err)
+		}
+	}
+	if _, err := bc.GetBlock(nil); err == nil || !strings.Contains(err.Error(), "empty hash") {
+		t.Errorf("GetBlock(nil) = %v, want an empty hash error", err)
+	}
+
+	// A database-backed chain reports unknown hashes the same way
+	db, err := NewBlockchain(filepath.Join(t.TempDir(), "chain.db"), POA, testTargetBits)
+	if err != nil {
+		t.Fatalf("NewBlockchain: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.GetBlock([]byte("missing")); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("GetBlock of an unknown hash on disk = %v, want ErrBlockNotFound", err)
+	}
+}
+
+func TestBlockHeights(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 3)
+	for i, block := range bc.mustChain() {
+		if block.Height != i {
+			t.Fatalf("block %d has height %d", i, block.Height)
+		}
+	}
+
+	// A block skipping a height, otherwise valid for the height it claims
+	tip := tipBlock(t, bc)
+	skipped := &Block{
+		Version:       BlockVersion,
+		Timestamp:     time.Now().Unix(),
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 2,
+		TargetBits:    nextTestDifficulty(t, bc),
+		Consensus:     POA,
+	}
+	skipped.ValidatorID = createMockAuthorities()[skipped.Height%3]
+	skipped.Hash = skipped.ComputeHash()
+
+	err := bc.AcceptBlock(skipped)
+	if err == nil || !strings.Contains(err.Error(), "height 5 does not follow parent height 3") {
+		t.Fatalf("AcceptBlock of a block skipping a height = %v, want height error", err)
+	}
+
+	forceTip(t, bc, skipped)
+	if err := bc.Validate(); err == nil || !strings.Contains(err.Error(), "block 4: height 5 does not follow block 3 height 3") {
+		t.Fatalf("Validate of a chain skipping a height = %v, want height error", err)
+	}
+}
+
+func TestGenesisConfigsGiveDistinctHashes(t *testing.T) {
+	// PoA involves no search, so the same contents always give the same hash
+	genesisHash := func(config GenesisConfig) []byte {
+		t.Helper()
+		bc, err := NewBlockchainWithStore(NewMemoryStore(), POA, testTargetBits, config)
+		if err != nil {
+			t.Fatalf("NewBlockchainWithStore: %v", err)
+		}
+		genesis := bc.mustChain()[0]
+		if genesis.Timestamp != config.Timestamp || string(genesis.Transactions[0].Data) != config.Data {
+			t.Fatalf("genesis has timestamp %d and data %q, want %d and %q",
+				genesis.Timestamp, genesis.Transactions[0].Data, config.Timestamp, config.Data)
+		}
+		return genesis.Hash
+	}
+
+	configs := []GenesisConfig{
+		{Data: "mainnet", Timestamp: 1700000000},
+		{Data: "testnet", Timestamp: 1700000000},
+		{Data: "mainnet", Timestamp: 1700000001},
+		{Data: "", Timestamp: 1700000000},
+	}
+	seen := make(map[string]int)
+	for i, config := range configs {
+		hash := string(genesisHash(config))
+		if j, ok := seen[hash]; ok {
+			t.Errorf("genesis configs %+v and %+v have the same hash", configs[j], config)
+		}
+		seen[hash] = i
+	}
+
+	// The same config always gives the same genesis
+	if a, b := genesisHash(configs[0]), genesisHash(configs[0]); !bytes.Equal(a, b) {
+		t.Errorf("the same genesis config gave hashes %x and %x", a, b)
+	}
+}
+
+// nextAuthorityBlock builds a valid PoA block on bc's tip with the given
+// timestamp, signed by the authority whose turn it is
+func nextAuthorityBlock(t *testing.T, bc *Blockchain, timestamp int64) *Block {
+	t.Helper()
+	tip := tipBlock(t, bc)
+	block := &Block{
+		Version:       BlockVersion,
+		Timestamp:     timestamp,
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 1,
+		TargetBits:    nextTestDifficulty(t, bc),
+		Consensus:     POA,
+	}
+	authorities := createMockAuthorities()
+	block.ValidatorID = authorities[block.Height%len(authorities)]
+	block.Hash = block.ComputeHash()
+	return block
+}
+
+func TestTimestampChecks(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 1)
+	tip := tipBlock(t, bc)
+
+	backwards := nextAuthorityBlock(t, bc, tip.Timestamp-1)
+	if err := bc.AcceptBlock(backwards); err == nil || !strings.Contains(err.Error(), "before parent timestamp") {
+		t.Fatalf("AcceptBlock of a backwards timestamp = %v, want rejection", err)
+	}
+
+	future := nextAuthorityBlock(t, bc, time.Now().Add(3*time.Hour).Unix())
+	if err := bc.AcceptBlock(future); err == nil || !strings.Contains(err.Error(), "in the future") {
+		t.Fatalf("AcceptBlock of a far-future timestamp = %v, want rejection", err)
+	}
+	// A larger allowance admits it
+	bc.SetMaxFutureDrift(4 * time.Hour)
+	if err := bc.AcceptBlock(future); err != nil {
+		t.Fatalf("AcceptBlock within the configured drift: %v", err)
+	}
+	bc.SetMaxFutureDrift(defaultMaxFutureDrift)
+	if err := bc.Validate(); err == nil || !strings.Contains(err.Error(), "block 2: timestamp") {
+		t.Fatalf("Validate of a far-future block = %v, want rejection", err)
+	}
+
+	// Validation catches a backwards block that bypassed AcceptBlock
+	bc = newTestChain(t, POA)
+	addTestBlocks(t, bc, 1)
+	forceTip(t, bc, nextAuthorityBlock(t, bc, tipBlock(t, bc).Timestamp-1))
+	if err := bc.Validate(); err == nil || !strings.Contains(err.Error(), "block 2: timestamp") || !strings.Contains(err.Error(), "before parent") {
+		t.Fatalf("Validate of a backwards block = %v, want rejection", err)
+	}
+
+	// A block may share its parent's timestamp
+	bc = newTestChain(t, POA)
+	if err := bc.AcceptBlock(nextAuthorityBlock(t, bc, tipBlock(t, bc).Timestamp)); err != nil {
+		t.Fatalf("AcceptBlock with the parent's timestamp: %v", err)
+	}
+}
+
+func TestAddBlockSizeLimit(t *testing.T) {
+	// PoA seals deterministically, so a block with the same payload at the
+	// same height always has the same size
+	source := newTestChain(t, POA)
+	payload := dataTx(strings.Repeat("x", 2048))
+	sized := forkTestChain(t, source)
+	if err := sized.AddBlock([]*Transaction{payload}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	data, err := tipBlock(t, sized).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(data)
+
+	// A payload exactly at the limit is accepted
+	atLimit := forkTestChain(t, source)
+	atLimit.SetMaxBlockSize(size)
+	if err := atLimit.AddBlock([]*Transaction{payload}); err != nil {
+		t.Fatalf("AddBlock of a %d byte block with a %d byte limit: %v", size, size, err)
+	}
+
+	// One byte more is rejected
+	overLimit := forkTestChain(t, source)
+	overLimit.SetMaxBlockSize(size - 1)
+	err = overLimit.AddBlock([]*Transaction{payload})
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("limit is %d", size-1)) {
+		t.Fatalf("AddBlock over the limit = %v, want size error", err)
+	}
+}
+
+func TestAddBlockChecksSizeBeforeMining(t *testing.T) {
+	bc := newTestChain(t, POW)
+	bc.SetMaxBlockSize(1024)
+	// No nonce meets this floor, so reaching the miner would hang until the
+	// context expires
+	bc.SetMinTargetBits(200)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := bc.AddBlockContext(ctx, []*Transaction{dataTx(strings.Repeat("x", 4096))})
+	if err == nil || !strings.Contains(err.Error(), "limit is 1024") {
+		t.Fatalf("AddBlockContext of an oversized block = %v, want size error", err)
+	}
+}
+
+func TestHeavierShorterChainWins(t *testing.T) {
+	heavy := newTestChain(t, POW)
+	light := forkTestChain(t, heavy)
+
+	// Quick blocks raise the difficulty: 8, 9, 10 bits after genesis
+	addTestBlocks(t, heavy, 3)
+
+	// Blocks a minute apart lower it: 8, 7, 6, 5, 4, 3 bits after genesis
+	for i := 1; i <= 6; i++ {
+		tip := tipBlock(t, light)
+		block, err := forgeBlock(context.Background(), nil, tip.Hash, tip.Height+1, tip.Timestamp+60, tip.ValidatorID, POW, nextTestDifficulty(t, light), nil)
+		if err != nil {
+			t.Fatalf("forgeBlock: %v", err)
+		}
+		if err := light.AcceptBlock(block); err != nil {
+			t.Fatalf("AcceptBlock of light block %d: %v", i, err)
+		}
+	}
+
+	heavyBlocks, lightBlocks := heavy.mustChain(), light.mustChain()
+	if len(lightBlocks) <= len(heavyBlocks) {
+		t.Fatalf("light chain has %d blocks, heavy %d; want the light one longer", len(lightBlocks), len(heavyBlocks))
+	}
+	heavyWork, lightWork := heavy.TotalDifficulty(), light.TotalDifficulty()
+	if heavyWork.Cmp(lightWork) <= 0 {
+		t.Fatalf("heavy chain has total difficulty %s, light %s; want the heavy one greater", heavyWork, lightWork)
+	}
+
+	// The longer chain doesn't replace the heavier one
+	err := heavy.ReplaceChain(lightBlocks)
+	if err == nil || !strings.Contains(err.Error(), "total difficulty") {
+		t.Fatalf("ReplaceChain with a longer but lighter chain = %v, want total difficulty error", err)
+	}
+
+	// The heavier chain replaces the longer one
+	if err := light.ReplaceChain(heavyBlocks); err != nil {
+		t.Fatalf("ReplaceChain with a shorter but heavier chain: %v", err)
+	}
+	if tip := tipBlock(t, light); !bytes.Equal(tip.Hash, heavyBlocks[len(heavyBlocks)-1].Hash) {
+		t.Fatalf("tip after ReplaceChain = %x, want the heavy chain's", tip.Hash)
+	}
+	if got := light.TotalDifficulty(); got.Cmp(heavyWork) != 0 {
+		t.Fatalf("total difficulty after ReplaceChain = %s, want %s", got, heavyWork)
+	}
+}
+
+func TestPrevHashRules(t *testing.T) {
+	bc := newTestChain(t, POA)
+	genesis := tipBlock(t, bc)
+
+	// A non-genesis block that doesn't reference its parent
+	orphan := nextAuthorityBlock(t, bc, genesis.Timestamp)
+	orphan.PrevBlockHash = []byte{}
+	orphan.Hash = orphan.ComputeHash()
+
+	err := bc.ReplaceChain([]*Block{genesis, orphan})
+	if err == nil || !strings.Contains(err.Error(), "block 1: missing previous hash") {
+		t.Fatalf("ReplaceChain with an empty previous hash = %v, want missing previous hash", err)
+	}
+	if err := bc.AcceptBlock(orphan); err == nil {
+		t.Fatal("AcceptBlock accepted a block with an empty previous hash")
+	}
+
+	// A genesis block claiming a parent
+	claimed := *genesis
+	claimed.PrevBlockHash = []byte("parent")
+	claimed.Hash = claimed.ComputeHash()
+	err = bc.validateBlocks([]*Block{&claimed})
+	if err == nil || !strings.Contains(err.Error(), "block 0: genesis has previous hash") {
+		t.Fatalf("validateBlocks of a genesis with a previous hash = %v, want genesis error", err)
+	}
+
+	if err := bc.validateBlocks([]*Block{genesis}); err != nil {
+		t.Fatalf("validateBlocks of the real genesis: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package main implements a BoltDB-backed block store
+package main
+
+import (
+	"fmt"  // for error messages
+	"time" // for the database open timeout
+
+	bolt "go.etcd.io/bbolt" // embedded key/value database
+)
+
+const (
+	blocksBucket = "blocks" // bucket holding serialized blocks keyed by hash
+	tipKey       = "l"      // key in blocksBucket holding the tip hash
+	metaBucket   = "meta"   // bucket holding chain metadata
+)
+
+// BoltStore is a Store that persists blocks to a BoltDB file
+type BoltStore struct {
+	db *bolt.DB // open database handle
+}
+
+// NewBoltStore opens (or creates) the BoltDB file at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{blocksBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Put saves a block keyed by its hash
+func (s *BoltStore) Put(block *Block) error {
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Put(block.Hash, data)
+	})
+}
+
+// Get returns the block with the given hash
+func (s *BoltStore) Get(hash []byte) (*Block, error) {
+	var block *Block
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+		if data == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+		}
+
+		var err error
+		block, err = DeserializeBlock(data)
+		return err
+	})
+	return block, err
+}
+
+// Tip returns the hash of the last block, or nil for an empty store
+func (s *BoltStore) Tip() ([]byte, error) {
+	var tip []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Copy the value, as Bolt's memory is only valid inside the transaction
+		if data := tx.Bucket([]byte(blocksBucket)).Get([]byte(tipKey)); data != nil {
+			tip = append([]byte{}, data...)
+		}
+		return nil
+	})
+	return tip, err
+}
+
+// SetTip records the hash of the last block
+func (s *BoltStore) SetTip(hash []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Put([]byte(tipKey), hash)
+	})
+}
+
+// PutMeta saves chain metadata under key
+func (s *BoltStore) PutMeta(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metaBucket)).Put([]byte(key), value)
+	})
+}
+
+// GetMeta returns the metadata saved under key, or nil if there is none
+func (s *BoltStore) GetMeta(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Copy the value, as Bolt's memory is only valid inside the transaction
+		if data := tx.Bucket([]byte(metaBucket)).Get([]byte(key)); data != nil {
+			value = append([]byte{}, data...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Close closes the underlying database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
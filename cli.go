@@ -0,0 +1,174 @@
+// Package main implements the command-line interface
+package main
+
+import (
+	"errors"  // for sentinel errors
+	"flag"    // for parsing command flags
+	"fmt"     // for printing
+	"io"      // for the output destination
+	"io/fs"   // for detecting a missing chain
+	"log"     // for logging chain progress
+	"os"      // for checking the chain file
+	"strings" // for parsing consensus names
+)
+
+// defaultDBPath is where the CLI keeps the chain between invocations
+const defaultDBPath = "blockchain.db"
+
+// ErrUnknownCommand is returned by CLI.Run for unrecognized commands
+var ErrUnknownCommand = errors.New("unknown command")
+
+// CLI runs commands against the chain persisted at dbPath
+type CLI struct {
+	dbPath string    // BoltDB file holding the chain
+	out    io.Writer // where command output is written
+}
+
+// NewCLI creates a CLI for the chain at dbPath, writing output to out
+func NewCLI(dbPath string, out io.Writer) *CLI {
+	return &CLI{dbPath: dbPath, out: out}
+}
+
+// usage describes the available commands
+const usage = `Usage:
+  createblockchain -consensus pow|pos|poa|dpos  create a new chain
+  addblock -data DATA                           add a block carrying DATA
+  printchain                                    print every block, tip first`
+
+// Run executes the command named by args[0] with the remaining args as flags
+func (cli *CLI) Run(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(cli.out, usage)
+		return fmt.Errorf("%w: none given", ErrUnknownCommand)
+	}
+
+	switch args[0] {
+	case "createblockchain":
+		return cli.createBlockchain(args[1:])
+	case "addblock":
+		return cli.addBlock(args[1:])
+	case "printchain":
+		return cli.printChain(args[1:])
+	default:
+		fmt.Fprintln(cli.out, usage)
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, args[0])
+	}
+}
+
+// newFlagSet creates a flag set for a command that reports errors instead
+// of exiting
+func (cli *CLI) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(cli.out)
+	return flags
+}
+
+// createBlockchain creates a new chain with the requested consensus
+func (cli *CLI) createBlockchain(args []string) error {
+	flags := cli.newFlagSet("createblockchain")
+	consensusName := flags.String("consensus", "pow", "consensus mechanism: pow, pos, poa or dpos")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	consensusType, err := ParseConsensusType(*consensusName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(cli.dbPath); err == nil {
+		return fmt.Errorf("blockchain already exists at %s", cli.dbPath)
+	}
+
+	bc, err := NewBlockchain(cli.dbPath, consensusType, defaultTargetBits)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	fmt.Fprintf(cli.out, "Created %s blockchain at %s\n", consensusType, cli.dbPath)
+	return nil
+}
+
+// addBlock adds a block holding the given data to an existing chain
+func (cli *CLI) addBlock(args []string) error {
+	flags := cli.newFlagSet("addblock")
+	data := flags.String("data", "", "data to store in the block")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	bc.SetLogger(log.New(cli.out, "", 0))
+
+	tx := &Transaction{Data: []byte(*data)}
+	tx.ID = tx.Hash()
+	return bc.AddBlock([]*Transaction{tx})
+}
+
+// printChain prints every block from the tip back to genesis
+func (cli *CLI) printChain(args []string) error {
+	if err := cli.newFlagSet("printchain").Parse(args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	blocks, err := bc.Blocks()
+	if err != nil {
+		return err
+	}
+
+	// Print from the tip back, validating each block after its parent's
+	// validator as the chain does
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		fmt.Fprintf(cli.out, "%s\n", block)
+
+		var prevValidatorID []byte
+		if i > 0 {
+			prevValidatorID = blocks[i-1].ValidatorID
+		}
+		valid, err := newConsensusAfter(block.Consensus, block, prevValidatorID, nil).Validate()
+		if err != nil {
+			fmt.Fprintf(cli.out, "Valid: %v\n\n", err)
+			continue
+		}
+		fmt.Fprintf(cli.out, "Valid: %t\n\n", valid)
+	}
+	return nil
+}
+
+// openBlockchain opens the existing chain, which keeps the consensus it was
+// created with
+func (cli *CLI) openBlockchain() (*Blockchain, error) {
+	if _, err := os.Stat(cli.dbPath); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no blockchain at %s, run createblockchain first", cli.dbPath)
+	}
+
+	return NewBlockchain(cli.dbPath, POW, defaultTargetBits)
+}
+
+// ParseConsensusType converts a name such as "pow" to its ConsensusType
+func ParseConsensusType(name string) (ConsensusType, error) {
+	switch strings.ToLower(name) {
+	case "pow":
+		return POW, nil
+	case "pos":
+		return POS, nil
+	case "poa":
+		return POA, nil
+	case "dpos":
+		return DPOS, nil
+	default:
+		return 0, fmt.Errorf("unknown consensus %q", name)
+	}
+}
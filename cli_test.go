@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"         // for capturing output
+	"path/filepath" // for the database path
+	"strings"       // for inspecting output
+	"testing"       // for the test harness
+)
+
+func TestPrintChainValidatesAfterParent(t *testing.T) {
+	var out bytes.Buffer
+	cli := NewCLI(filepath.Join(t.TempDir(), "chain.db"), &out)
+	if err := cli.Run([]string{"createblockchain", "-consensus", "pos"}); err != nil {
+		t.Fatalf("createblockchain: %v", err)
+	}
+	// Enough blocks that some validator would be selected twice in a row
+	// if the previous one weren't excluded
+	for i := 0; i < 10; i++ {
+		if err := cli.Run([]string{"addblock", "-data", "block"}); err != nil {
+			t.Fatalf("addblock: %v", err)
+		}
+	}
+
+	out.Reset()
+	if err := cli.Run([]string{"printchain"}); err != nil {
+		t.Fatalf("printchain: %v", err)
+	}
+	if got := strings.Count(out.String(), "Valid: true"); got != 11 {
+		t.Fatalf("printchain reports %d valid blocks, want 11:\n%s", got, out.String())
+	}
+}
+
+func TestPrintChainShowsBlockData(t *testing.T) {
+	var out bytes.Buffer
+	cli := NewCLI(filepath.Join(t.TempDir(), "chain.db"), &out)
+	if err := cli.Run([]string{"createblockchain", "-consensus", "poa"}); err != nil {
+		t.Fatalf("createblockchain: %v", err)
+	}
+	if err := cli.Run([]string{"addblock", "-data", "send 1 coin to Ivan"}); err != nil {
+		t.Fatalf("addblock: %v", err)
+	}
+
+	out.Reset()
+	if err := cli.Run([]string{"printchain"}); err != nil {
+		t.Fatalf("printchain: %v", err)
+	}
+	if !strings.Contains(out.String(), `Data: "send 1 coin to Ivan"`) {
+		t.Fatalf("printchain doesn't show the block data:\n%s", out.String())
+	}
+}
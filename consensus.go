@@ -1,6 +1,13 @@
 // Package main defines consensus mechanisms
 package main
 
+import (
+	"context" // for cancelling consensus
+	"fmt"     // for formatting unknown types
+	"sync"    // for guarding the registry
+	"time"    // for watchdog durations
+)
+
 // ConsensusType represents the type of consensus mechanism
 type ConsensusType int
 
@@ -9,22 +16,109 @@ const (
 	POW ConsensusType = iota
 	// POS represents Proof of Stake consensus
 	POS
+	// POA represents Proof of Authority consensus
+	POA
+	// DPOS represents Delegated Proof of Stake consensus
+	DPOS
 )
 
+// String returns the conventional abbreviation of the consensus type
+func (t ConsensusType) String() string {
+	switch t {
+	case POW:
+		return "PoW"
+	case POS:
+		return "PoS"
+	case POA:
+		return "PoA"
+	case DPOS:
+		return "DPoS"
+	default:
+		return fmt.Sprintf("ConsensusType(%d)", int(t))
+	}
+}
+
 // Consensus interface defines methods that any consensus mechanism must implement
 type Consensus interface {
-	// Run executes the consensus algorithm and returns necessary data
-	Run() ([]byte, []byte) // returns validator/miner ID and hash
-	// Validate verifies the block according to consensus rules
-	Validate() bool
+	// Run executes the consensus algorithm and returns necessary data,
+	// giving up with ctx.Err() if ctx is cancelled first
+	Run(ctx context.Context) ([]byte, []byte, error) // returns validator/miner ID and hash
+	// Validate verifies the block according to consensus rules. An error
+	// means the block couldn't be checked, rather than that it's invalid.
+	Validate() (bool, error)
 }
 
-// NewConsensus creates a new consensus mechanism based on the type
+// ConsensusFactory builds a consensus mechanism for a block
+type ConsensusFactory func(block *Block) Consensus
+
+var (
+	registryMu sync.RWMutex // guards registry
+	// registry maps consensus types to their factories. Until validators
+	// are read from the chain, PoS selects among the mock validators DPoS
+	// also elects from.
+	registry = map[ConsensusType]ConsensusFactory{
+		POW:  func(b *Block) Consensus { return NewProofOfWork(b) },
+		POS:  func(b *Block) Consensus { return NewProofOfStake(b, createMockValidators()...) },
+		POA:  func(b *Block) Consensus { return NewProofOfAuthority(b) },
+		DPOS: func(b *Block) Consensus { return NewDPoS(b) },
+	}
+)
+
+// RegisterConsensus makes a consensus mechanism available to NewConsensus
+// under the given type, replacing any existing registration
+func RegisterConsensus(t ConsensusType, factory func(*Block) Consensus) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[t] = factory
+}
+
+// NewConsensus creates a new consensus mechanism based on the type,
+// falling back to proof-of-work for unregistered types
 func NewConsensus(consensusType ConsensusType, block *Block) Consensus {
-	switch consensusType {
-	case POS:
-		return NewProofOfStake(block)
-	default:
+	registryMu.RLock()
+	factory, ok := registry[consensusType]
+	registryMu.RUnlock()
+
+	if !ok {
 		return NewProofOfWork(block)
 	}
+	return factory(block)
+}
+
+// previousValidatorSetter is implemented by consensus mechanisms whose rules
+// depend on who produced the preceding block
+type previousValidatorSetter interface {
+	SetPreviousValidator(validatorID []byte)
+}
+
+// validatorCounter is implemented by consensus mechanisms producing blocks
+// from a set of validators
+type validatorCounter interface {
+	ValidatorCount() int
+}
+
+// watchdogSetter is implemented by consensus mechanisms that can report
+// producing a block taking too long
+type watchdogSetter interface {
+	SetWatchdog(d time.Duration, onStuck func(elapsed time.Duration))
+}
+
+// blockRewardSetter is implemented by consensus mechanisms that reward the
+// producer of each block
+type blockRewardSetter interface {
+	SetBlockReward(reward uint64)
+}
+
+// newConsensusAfter is NewConsensus for a block following one produced by
+// prevValidatorID, which is nil for genesis. A non-nil logger receives the
+// mechanism's status messages.
+func newConsensusAfter(consensusType ConsensusType, block *Block, prevValidatorID []byte, logger Logger) Consensus {
+	consensus := NewConsensus(consensusType, block)
+	if setter, ok := consensus.(previousValidatorSetter); ok {
+		setter.SetPreviousValidator(prevValidatorID)
+	}
+	if setter, ok := consensus.(loggerSetter); ok && logger != nil {
+		setter.SetLogger(logger)
+	}
+	return consensus
 }
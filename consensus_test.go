@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"   // for comparing block fields
+	"context" // for building blocks
+	"testing" // for the test harness
+)
+
+// stubConsensus records its calls and signs blocks with a fixed ID
+type stubConsensus struct {
+	block *Block // block the stub was built for
+	runs  *int   // incremented by every Run
+}
+
+func (s stubConsensus) Run(ctx context.Context) ([]byte, []byte, error) {
+	*s.runs++
+	return []byte("stub"), s.block.ComputeHash(), nil
+}
+
+func (s stubConsensus) Validate() (bool, error) {
+	return bytes.Equal(s.block.ValidatorID, []byte("stub")), nil
+}
+
+// registerTestConsensus registers factory under t for the rest of the test
+func registerTestConsensus(t *testing.T, consensusType ConsensusType, factory func(*Block) Consensus) {
+	t.Helper()
+	RegisterConsensus(consensusType, factory)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		delete(registry, consensusType)
+	})
+}
+
+func TestRegisteredConsensusRuns(t *testing.T) {
+	const stubType ConsensusType = 100
+	runs := 0
+	var built *Block
+	registerTestConsensus(t, stubType, func(b *Block) Consensus {
+		built = b
+		return stubConsensus{block: b, runs: &runs}
+	})
+
+	block, err := NewBlock(context.Background(), []*Transaction{dataTx("stub")}, []byte("parent"), 1, stubType, 0)
+	if err != nil {
+		t.Fatalf("NewBlock: %v", err)
+	}
+	if runs != 1 {
+		t.Fatalf("stub Run called %d times, want 1", runs)
+	}
+	if built != block {
+		t.Error("stub was built for a different block")
+	}
+	if string(block.ValidatorID) != "stub" {
+		t.Errorf("block validator ID = %q, want the stub's", block.ValidatorID)
+	}
+	if valid, err := NewConsensus(stubType, block).Validate(); !valid || err != nil {
+		t.Errorf("stub Validate = %v, %v", valid, err)
+	}
+}
+
+func TestUnregisteredConsensusFallsBackToPoW(t *testing.T) {
+	if _, ok := NewConsensus(ConsensusType(101), &Block{}).(*ProofOfWork); !ok {
+		t.Fatal("NewConsensus of an unregistered type did not fall back to PoW")
+	}
+}
@@ -0,0 +1,116 @@
+// Package main implements delegated proof-of-stake system
+package main
+
+import (
+	"bytes"         // for comparing addresses
+	"context"       // for cancelling consensus
+	"crypto/sha256" // for hashing
+	"errors"        // for error values
+	"sort"          // for ranking delegates by votes
+)
+
+// delegateCount is how many top-voted candidates become block producers
+const delegateCount = 2
+
+// Vote is a token holder's backing of a delegate candidate
+type Vote struct {
+	Voter    []byte // address of the token holder
+	Delegate []byte // address of the candidate voted for
+	Weight   uint64 // tokens behind the vote
+}
+
+// DPoS represents a delegated proof-of-stake system, where elected delegates
+// produce blocks in a fixed schedule
+type DPoS struct {
+	block     *Block       // pointer to the block being produced or validated
+	delegates []*Validator // elected producers, in schedule order
+}
+
+// NewDPoS builds and returns a DPoS
+func NewDPoS(b *Block) *DPoS {
+	// In a real implementation, candidates and votes would be read from the chain
+	// Here we use mock data for demonstration
+	return &DPoS{
+		block:     b,
+		delegates: ElectDelegates(createMockValidators(), createMockVotes(), delegateCount),
+	}
+}
+
+// createMockVotes creates test votes for the mock validators
+func createMockVotes() []Vote {
+	return []Vote{
+		{Voter: []byte("holder1"), Delegate: []byte("validator1"), Weight: 500},
+		{Voter: []byte("holder2"), Delegate: []byte("validator2"), Weight: 300},
+		{Voter: []byte("holder3"), Delegate: []byte("validator3"), Weight: 200},
+		{Voter: []byte("holder4"), Delegate: []byte("validator1"), Weight: 100},
+	}
+}
+
+// ElectDelegates tallies votes and returns up to n candidates with the most
+// vote weight, ties broken by address so every node elects the same set
+func ElectDelegates(candidates []*Validator, votes []Vote, n int) []*Validator {
+	tally := make(map[string]uint64)
+	for _, vote := range votes {
+		tally[string(vote.Delegate)] += vote.Weight
+	}
+
+	ranked := make([]*Validator, 0, len(candidates))
+	for _, c := range candidates {
+		if tally[string(c.Address)] > 0 {
+			ranked = append(ranked, c)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		wi, wj := tally[string(ranked[i].Address)], tally[string(ranked[j].Address)]
+		if wi != wj {
+			return wi > wj
+		}
+		return bytes.Compare(ranked[i].Address, ranked[j].Address) < 0
+	})
+
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// ValidatorCount returns how many delegates produce blocks
+func (d *DPoS) ValidatorCount() int {
+	return len(d.delegates)
+}
+
+// scheduledDelegate returns the delegate whose slot the block's height falls in
+func (d *DPoS) scheduledDelegate() (*Validator, error) {
+	if len(d.delegates) == 0 {
+		return nil, errors.New("no delegates elected")
+	}
+	if d.block.Height < 0 {
+		return nil, errors.New("negative block height")
+	}
+	return d.delegates[d.block.Height%len(d.delegates)], nil
+}
+
+// Run produces the block as the scheduled delegate
+// Returns delegate address and resulting hash
+func (d *DPoS) Run(ctx context.Context) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	delegate, err := d.scheduledDelegate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := sha256.Sum256(d.block.hashData(delegate.Address))
+	return delegate.Address, hash[:], nil
+}
+
+// Validate verifies the block was produced by the delegate scheduled for its slot
+func (d *DPoS) Validate() (bool, error) {
+	delegate, err := d.scheduledDelegate()
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(d.block.ValidatorID, delegate.Address), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"   // for comparing addresses
+	"context" // for running consensus
+	"testing" // for the test harness
+)
+
+func TestElectDelegatesByVoteWeight(t *testing.T) {
+	delegates := ElectDelegates(createMockValidators(), createMockVotes(), delegateCount)
+
+	// validator1 has 600 votes and validator2 300, leaving validator3 out
+	want := []string{"validator1", "validator2"}
+	if len(delegates) != len(want) {
+		t.Fatalf("elected %d delegates, want %d", len(delegates), len(want))
+	}
+	for i, address := range want {
+		if string(delegates[i].Address) != address {
+			t.Errorf("delegate %d = %s, want %s", i, delegates[i].Address, address)
+		}
+	}
+}
+
+func TestDPoSFollowsSchedule(t *testing.T) {
+	bc := newTestChain(t, DPOS)
+	addTestBlocks(t, bc, 5)
+
+	schedule := ElectDelegates(createMockValidators(), createMockVotes(), delegateCount)
+	for _, block := range bc.mustChain() {
+		if want := schedule[block.Height%len(schedule)].Address; !bytes.Equal(block.ValidatorID, want) {
+			t.Errorf("block %d produced by %s, want %s", block.Height, block.ValidatorID, want)
+		}
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestDPoSRejectsUnauthorizedProducer(t *testing.T) {
+	block := &Block{Height: 4, Consensus: DPOS}
+	producer, hash, err := NewDPoS(block).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	block.ValidatorID, block.Hash = producer, hash
+	if valid, err := NewDPoS(block).Validate(); !valid || err != nil {
+		t.Fatalf("Validate of the scheduled producer = %v, %v", valid, err)
+	}
+
+	// validator2 is a delegate but not scheduled for this slot, and
+	// validator3 wasn't elected at all
+	for _, producer := range []string{"validator2", "validator3", "nobody"} {
+		block.ValidatorID = []byte(producer)
+		block.Hash = block.ComputeHash()
+		if valid, err := NewDPoS(block).Validate(); valid || err != nil {
+			t.Errorf("Validate of a block produced by %s = %v, %v, want false", producer, valid, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package main implements an in-process event bus for chain events
+package main
+
+import "sync" // for guarding the subscriber list
+
+// EventType identifies the kind of chain event
+type EventType int
+
+const (
+	// BlockAdded is published after a block is appended to the chain
+	BlockAdded EventType = iota
+	// ChainReorged is published after the active chain is replaced
+	ChainReorged
+	// ValidatorSlashed is published after a validator is penalized
+	ValidatorSlashed
+	// ConsensusSwitched is published after the consensus mechanism changes
+	ConsensusSwitched
+)
+
+// eventBufferSize is how many undelivered events a subscriber can hold
+const eventBufferSize = 16
+
+// Event describes something that happened to the chain
+type Event struct {
+	Type      EventType     // kind of event
+	Block     *Block        // block involved, if any
+	Consensus ConsensusType // consensus type in effect after the event
+	Address   []byte        // validator involved, if any
+}
+
+// EventBus fans chain events out to subscribers
+type EventBus struct {
+	mu          sync.Mutex                 // guards subscribers
+	subscribers map[EventType][]chan Event // subscriber channels by event type
+}
+
+// NewEventBus creates an EventBus with no subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[EventType][]chan Event)}
+}
+
+// Subscribe returns a channel receiving future events of the given type. The
+// channel holds up to eventBufferSize undelivered events; further events are
+// dropped until the subscriber catches up.
+func (eb *EventBus) Subscribe(eventType EventType) <-chan Event {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	ch := make(chan Event, eventBufferSize)
+	eb.subscribers[eventType] = append(eb.subscribers[eventType], ch)
+	return ch
+}
+
+// Publish delivers the event to all subscribers of its type. Subscribers
+// that aren't keeping up miss the event rather than stalling the chain.
+func (eb *EventBus) Publish(event Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for _, ch := range eb.subscribers[event.Type] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
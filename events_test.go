@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"   // for comparing hashes
+	"testing" // for the test harness
+	"time"    // for bounding waits
+)
+
+func TestAddBlockPublishesBlockAdded(t *testing.T) {
+	bc := newTestChain(t, POW)
+	events := bc.Subscribe(BlockAdded)
+	reorgs := bc.Subscribe(ChainReorged)
+
+	addTestBlocks(t, bc, 1)
+	tip := tipBlock(t, bc)
+
+	select {
+	case event := <-events:
+		if event.Type != BlockAdded || !bytes.Equal(event.Block.Hash, tip.Hash) || event.Consensus != POW {
+			t.Fatalf("event = %+v, want BlockAdded for block %x", event, tip.Hash)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no BlockAdded event after AddBlock")
+	}
+
+	// Subscribers only receive events of their type
+	select {
+	case event := <-reorgs:
+		t.Fatalf("ChainReorged subscriber received %+v", event)
+	default:
+	}
+}
+
+func TestPublishDropsEventsForSlowSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	events := bus.Subscribe(BlockAdded)
+
+	// Publishing never blocks, even once the buffer is full
+	for i := 0; i < eventBufferSize+5; i++ {
+		bus.Publish(Event{Type: BlockAdded, Block: &Block{Height: i}})
+	}
+	if len(events) != eventBufferSize {
+		t.Fatalf("subscriber holds %d events, want %d", len(events), eventBufferSize)
+	}
+	if event := <-events; event.Block.Height != 0 {
+		t.Fatalf("first event is for height %d, want 0", event.Block.Height)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/lewislovelock/gochain
+
+go 1.25
+
+require (
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,39 @@
+// Package main implements traversal of the blockchain
+package main
+
+// BlockchainIterator walks the chain from the tip back to genesis
+type BlockchainIterator struct {
+	bc          *Blockchain // the chain being walked
+	currentHash []byte      // hash of the next block to return, empty when done
+}
+
+// Iterator returns an iterator starting at the current tip. Blocks added
+// after it is created are not visited.
+func (bc *Blockchain) Iterator() *BlockchainIterator {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	tip, err := bc.store.Tip()
+	if err != nil {
+		panic(err)
+	}
+	return &BlockchainIterator{bc: bc, currentHash: tip}
+}
+
+// Next returns the next block towards genesis, or nil once genesis has been
+// returned. Like mustChain it panics if a block on the chain is missing, as
+// that means the store is corrupt.
+func (it *BlockchainIterator) Next() *Block {
+	if len(it.currentHash) == 0 {
+		return nil
+	}
+
+	it.bc.mu.RLock()
+	block, err := it.bc.store.Get(it.currentHash)
+	it.bc.mu.RUnlock()
+	if err != nil {
+		panic(err)
+	}
+	it.currentHash = block.PrevBlockHash
+	return block
+}
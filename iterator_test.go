@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"   // for comparing hashes
+	"testing" // for the test harness
+)
+
+func TestIteratorWalksTipToGenesis(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 4)
+	want := bc.mustChain()
+
+	it := bc.Iterator()
+	// Blocks added after the iterator was created aren't visited
+	addTestBlocks(t, bc, 1)
+
+	for i := len(want) - 1; i >= 0; i-- {
+		block := it.Next()
+		if block == nil {
+			t.Fatalf("iteration stopped before height %d", i)
+		}
+		if !bytes.Equal(block.Hash, want[i].Hash) {
+			t.Fatalf("iteration visited %x at height %d, want %x", block.Hash, block.Height, want[i].Hash)
+		}
+	}
+
+	// Genesis ends the walk, and further calls keep returning nil
+	for i := 0; i < 2; i++ {
+		if block := it.Next(); block != nil {
+			t.Fatalf("Next after genesis returned height %d", block.Height)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package main implements JSON encoding of blocks and chains
+package main
+
+import (
+	"encoding/hex"  // for rendering hashes
+	"encoding/json" // for JSON encoding
+	"fmt"           // for error messages
+	"time"          // for rendering timestamps
+)
+
+// blockJSON is the JSON form of a Block, with byte slices as hex strings
+// and the timestamp in RFC3339
+type blockJSON struct {
+	Version           int32          `json:"version"`
+	Timestamp         string         `json:"timestamp"`
+	Transactions      []*Transaction `json:"transactions"`
+	PrevBlockHash     string         `json:"prevBlockHash"`
+	Height            int            `json:"height"`
+	Hash              string         `json:"hash"`
+	ValidatorID       string         `json:"validatorId"`
+	TargetBits        int            `json:"targetBits"`
+	Consensus         ConsensusType  `json:"consensus"`
+	Witness           string         `json:"witness,omitempty"`
+	WitnessCommitment string         `json:"witnessCommitment,omitempty"`
+	Signature         string         `json:"signature,omitempty"`
+	ValidatorStake    uint64         `json:"validatorStake,omitempty"`
+	TotalStake        uint64         `json:"totalStake,omitempty"`
+	ValidatorReward   uint64         `json:"validatorReward,omitempty"`
+}
+
+// MarshalJSON encodes the block with hex hashes and an RFC3339 timestamp
+func (b *Block) MarshalJSON() ([]byte, error) {
+	return json.Marshal(blockJSON{
+		Version:           b.Version,
+		Timestamp:         time.Unix(b.Timestamp, 0).UTC().Format(time.RFC3339),
+		Transactions:      b.Transactions,
+		PrevBlockHash:     hex.EncodeToString(b.PrevBlockHash),
+		Height:            b.Height,
+		Hash:              hex.EncodeToString(b.Hash),
+		ValidatorID:       hex.EncodeToString(b.ValidatorID),
+		TargetBits:        b.TargetBits,
+		Consensus:         b.Consensus,
+		Witness:           hex.EncodeToString(b.Witness),
+		WitnessCommitment: hex.EncodeToString(b.WitnessCommitment),
+		Signature:         hex.EncodeToString(b.Signature),
+		ValidatorStake:    b.ValidatorStake,
+		TotalStake:        b.TotalStake,
+		ValidatorReward:   b.ValidatorReward,
+	})
+}
+
+// UnmarshalJSON decodes a block encoded by MarshalJSON
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v blockJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, v.Timestamp)
+	if err != nil {
+		return fmt.Errorf("decode timestamp: %w", err)
+	}
+
+	// Decode every hex field, naming the one that fails. Optional fields
+	// are nil when absent, as in blocks that never set them.
+	fields := []struct {
+		name     string
+		src      string
+		dst      *[]byte
+		optional bool
+	}{
+		{"prevBlockHash", v.PrevBlockHash, &b.PrevBlockHash, false},
+		{"hash", v.Hash, &b.Hash, false},
+		{"validatorId", v.ValidatorID, &b.ValidatorID, false},
+		{"witness", v.Witness, &b.Witness, true},
+		{"signature", v.Signature, &b.Signature, true},
+		{"witnessCommitment", v.WitnessCommitment, &b.WitnessCommitment, true},
+	}
+	for _, field := range fields {
+		if field.optional && field.src == "" {
+			*field.dst = nil
+			continue
+		}
+		decoded, err := hex.DecodeString(field.src)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", field.name, err)
+		}
+		*field.dst = decoded
+	}
+
+	b.Version = v.Version
+	b.Timestamp = timestamp.Unix()
+	b.Transactions = v.Transactions
+	b.Height = v.Height
+	b.TargetBits = v.TargetBits
+	b.Consensus = v.Consensus
+	b.ValidatorStake = v.ValidatorStake
+	b.TotalStake = v.TotalStake
+	b.ValidatorReward = v.ValidatorReward
+	return nil
+}
+
+// MarshalJSON encodes the chain as an array of blocks from genesis to tip
+func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(blocks)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json" // for encoding blocks
+	"reflect"       // for comparing decoded blocks
+	"strings"       // for inspecting encoded blocks
+	"testing"       // for the test harness
+)
+
+// roundTripJSON encodes v as JSON and decodes the result into out
+func roundTripJSON(t *testing.T, v, out interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return data
+}
+
+func TestBlockJSONRoundTrip(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 50, Address: newTestWallet(t).Address()})
+	if err := bc.AddBlock([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	pos := newTestChain(t, POS)
+	addTestBlocks(t, pos, 1)
+
+	witnessed := &Block{Height: 1, Timestamp: 1700000000, PrevBlockHash: []byte("parent"), Consensus: POA, ValidatorID: []byte("authority2")}
+	witnessed.SetWitness([]byte("segregated"))
+	witnessed.Hash = witnessed.ComputeHash()
+
+	blocks := append(bc.mustChain(), tipBlock(t, pos), witnessed)
+	for _, block := range blocks {
+		var decoded Block
+		data := roundTripJSON(t, block, &decoded)
+		if !reflect.DeepEqual(&decoded, block) {
+			t.Errorf("block %d changed in a JSON round-trip:\n got %#v\nwant %#v\n%s", block.Height, decoded, *block, data)
+		}
+	}
+}
+
+func TestBlockJSONUsesHexAndRFC3339(t *testing.T) {
+	block := &Block{Timestamp: 1700000000, Hash: []byte{0xab, 0xcd}, PrevBlockHash: []byte{0x01}, ValidatorID: []byte{0xff}}
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, want := range []string{`"hash":"abcd"`, `"prevBlockHash":"01"`, `"validatorId":"ff"`, `"timestamp":"2023-11-14T22:13:20Z"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("encoded block lacks %s: %s", want, data)
+		}
+	}
+
+	var decoded Block
+	if err := json.Unmarshal([]byte(`{"timestamp":"2023-11-14T22:13:20Z","hash":"zz"}`), &decoded); err == nil || !strings.Contains(err.Error(), "decode hash") {
+		t.Errorf("Unmarshal of a bad hash = %v, want decode hash error", err)
+	}
+}
+
+func TestBlockchainJSONRoundTrip(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 3)
+
+	var decoded []*Block
+	roundTripJSON(t, bc, &decoded)
+	if want := bc.mustChain(); !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("chain changed in a JSON round-trip: got %d blocks, want %d", len(decoded), len(want))
+	}
+}
@@ -0,0 +1,19 @@
+// Package main implements pluggable logging
+package main
+
+// Logger receives status messages from the chain and its consensus
+// mechanisms. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// nopLogger discards every message, so nothing is printed by default
+type nopLogger struct{}
+
+// Printf does nothing
+func (nopLogger) Printf(format string, args ...interface{}) {}
+
+// loggerSetter is implemented by consensus mechanisms that log their progress
+type loggerSetter interface {
+	SetLogger(logger Logger)
+}
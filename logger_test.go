@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"   // for buffering log output
+	"fmt"     // for expected log lines
+	"io"      // for draining captured output
+	"log"     // for a buffer-backed logger
+	"os"      // for capturing standard output
+	"strings" // for splitting log lines
+	"testing" // for the test harness
+)
+
+func TestMiningLogsThroughLogger(t *testing.T) {
+	bc := newTestChain(t, POW)
+	var buf bytes.Buffer
+	bc.SetLogger(log.New(&buf, "", 0))
+	addTestBlocks(t, bc, 1)
+
+	tip := tipBlock(t, bc)
+	nonce, err := IntFromHex(tip.ValidatorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Mining a new block...",
+		fmt.Sprintf("Block mined! Nonce: %d", nonce),
+		fmt.Sprintf("Added block 1: %x", tip.Hash),
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("mining logged:\n%s\nwant:\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestDefaultLoggerIsSilent(t *testing.T) {
+	// Capture anything written to the standard streams while mining
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	logOutput := log.Writer()
+	log.SetOutput(w)
+	defer log.SetOutput(logOutput)
+
+	bc := newTestChain(t, POW)
+	addTestBlocks(t, bc, 2)
+	if err := bc.SwitchConsensus(POS); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlocks(t, bc, 1)
+
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("default logger printed:\n%s", out)
+	}
+}
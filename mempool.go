@@ -0,0 +1,188 @@
+// Package main implements a pool of unconfirmed transactions
+package main
+
+import (
+	"encoding/hex" // for keying transactions by ID
+	"fmt"          // for error messages
+	"sort"         // for ordering transactions by fee
+	"sync"         // for guarding the pool
+)
+
+// Mempool holds transactions waiting to be included in a block. Higher-fee
+// transactions are taken first, and equal fees in the order they arrived.
+type Mempool struct {
+	mu    sync.Mutex        // guards txs, fees and spent
+	txs   []*Transaction    // pending transactions, oldest first
+	fees  map[string]int    // fees of pending transactions by hex ID
+	spent map[string][]byte // IDs of pending transactions by the outpoint they spend
+
+	// check, if set, verifies tx against the chain and returns the fee it
+	// pays
+	check func(tx *Transaction) (int, error)
+}
+
+// NewMempool creates an empty Mempool that accepts any transaction not
+// conflicting with a pending one, treating each as paying no fee, so they
+// are taken in arrival order
+func NewMempool() *Mempool {
+	return &Mempool{fees: make(map[string]int), spent: make(map[string][]byte)}
+}
+
+// NewMempool creates an empty Mempool that accepts only transactions valid
+// on bc, prioritizing them by the fee they pay. The chain reports the size
+// of the latest pool made this way in its metrics.
+func (bc *Blockchain) NewMempool() *Mempool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	pool := NewMempool()
+	pool.check = func(tx *Transaction) (int, error) {
+		bc.mu.RLock()
+		defer bc.mu.RUnlock()
+		return bc.verifyTransaction(tx)
+	}
+	bc.mempool = pool
+	return pool
+}
+
+// Add queues a transaction, rejecting one already pending, one spending an
+// output a pending transaction spends, and, for a pool made by a
+// Blockchain, one the chain doesn't accept
+func (m *Mempool) Add(tx *Transaction) error {
+	// Check against the chain before locking the pool, so the chain is
+	// never waited on while the pool is locked
+	fee := 0
+	if m.check != nil {
+		var err error
+		if fee, err = m.check(tx); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := m.fees[id]; ok {
+		return fmt.Errorf("transaction %x is already pending", tx.ID)
+	}
+	if err := m.checkConflicts(tx); err != nil {
+		return err
+	}
+
+	m.add(tx, fee)
+	return nil
+}
+
+// checkConflicts rejects tx if it spends an output a pending transaction
+// spends. m.mu must be held.
+func (m *Mempool) checkConflicts(tx *Transaction) error {
+	for _, in := range tx.Vin {
+		if other, ok := m.spent[outpoint(in.Txid, in.Vout)]; ok {
+			return fmt.Errorf("transaction %x spends output %d of %x, which pending transaction %x already spends", tx.ID, in.Vout, in.Txid, other)
+		}
+	}
+	return nil
+}
+
+// add appends tx to the pool. m.mu must be held.
+func (m *Mempool) add(tx *Transaction, fee int) {
+	m.txs = append(m.txs, tx)
+	m.fees[hex.EncodeToString(tx.ID)] = fee
+	for _, in := range tx.Vin {
+		m.spent[outpoint(in.Txid, in.Vout)] = tx.ID
+	}
+}
+
+// Take removes and returns up to n pending transactions, highest fee first
+func (m *Mempool) Take(n int) []*Transaction {
+	txs, _ := m.take(n)
+	return txs
+}
+
+// take is Take, also returning the fee of each transaction
+func (m *Mempool) take(n int) ([]*Transaction, []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n > len(m.txs) {
+		n = len(m.txs)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// A stable sort keeps arrival order among equal fees
+	sort.SliceStable(m.txs, func(i, j int) bool {
+		return m.fees[hex.EncodeToString(m.txs[i].ID)] > m.fees[hex.EncodeToString(m.txs[j].ID)]
+	})
+
+	taken := append([]*Transaction(nil), m.txs[:n]...)
+	fees := make([]int, n)
+	m.txs = m.txs[n:]
+	for i, tx := range taken {
+		id := hex.EncodeToString(tx.ID)
+		fees[i] = m.fees[id]
+		delete(m.fees, id)
+		for _, in := range tx.Vin {
+			delete(m.spent, outpoint(in.Txid, in.Vout))
+		}
+	}
+	return taken, fees
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.txs)
+}
+
+// restore puts transactions back at the front of the pool, after a block
+// including them failed. Transactions added again or conflicting with one
+// added since they were taken are dropped.
+func (m *Mempool) restore(txs []*Transaction, fees []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := m.txs
+	m.txs = nil
+	for i, tx := range txs {
+		if _, ok := m.fees[hex.EncodeToString(tx.ID)]; ok || m.checkConflicts(tx) != nil {
+			continue
+		}
+		m.add(tx, fees[i])
+	}
+	m.txs = append(m.txs, pending...)
+}
+
+// MineBlock adds a block holding up to maxTxs pending transactions from
+// pool, highest fee first. Transactions no longer valid on the chain are
+// dropped from pool. If the block can't be added, the rest are returned to
+// pool.
+func (bc *Blockchain) MineBlock(pool *Mempool, maxTxs int) error {
+	taken, takenFees := pool.take(maxTxs)
+
+	// Earlier transactions in the block may create the outputs later ones
+	// spend. AddBlock checks them again, in case the chain moves on first.
+	var txs []*Transaction
+	var fees []int
+	bc.mu.RLock()
+	view := newUTXOView(bc.utxo)
+	for i, tx := range taken {
+		if _, err := checkTransaction(tx, view); err != nil {
+			bc.logger.Printf("Dropped pending transaction %x: %v", tx.ID, err)
+			continue
+		}
+		view.apply(tx)
+		txs = append(txs, tx)
+		fees = append(fees, takenFees[i])
+	}
+	bc.mu.RUnlock()
+
+	if err := bc.AddBlock(txs); err != nil {
+		pool.restore(txs, fees)
+		return err
+	}
+	return nil
+}
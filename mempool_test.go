@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings" // for matching error messages
+	"testing" // for the test harness
+)
+
+func TestMempoolRejectsInvalidTransactions(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	pool := bc.NewMempool()
+
+	overspend := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: defaultInitialReward + 1, Address: w.Address()})
+	if err := pool.Add(overspend); err == nil {
+		t.Fatal("Add accepted a transaction spending more than its inputs")
+	}
+	if err := pool.Add(coinbase); err == nil {
+		t.Fatal("Add accepted a coinbase")
+	}
+
+	forged := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 10, Address: w.Address()})
+	forged.Vout[0].Value = 20
+	forged.ID = forged.Hash()
+	if err := pool.Add(forged); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("Add of a tampered transaction = %v, want signature error", err)
+	}
+	if pool.Len() != 0 {
+		t.Fatalf("pool holds %d transactions, want 0", pool.Len())
+	}
+}
+
+func TestMempoolRejectsConflicts(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	pool := bc.NewMempool()
+
+	first := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 40, Address: w.Address()})
+	second := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 30, Address: w.Address()})
+	if err := pool.Add(first); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := pool.Add(second); err == nil || !strings.Contains(err.Error(), "already spends") {
+		t.Fatalf("Add of a conflicting transaction = %v, want conflict error", err)
+	}
+
+	// Once the first is taken, its outputs are free again
+	pool.Take(1)
+	if err := pool.Add(second); err != nil {
+		t.Fatalf("Add after taking the conflicting transaction: %v", err)
+	}
+}
+
+func TestMineBlockDropsInvalidTransactions(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	pool := NewMempool()
+
+	valid := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 40, Address: w.Address()})
+	missing := &Transaction{Vin: []TXInput{{Txid: []byte("missing"), Vout: 0}}, Vout: []TXOutput{{Value: 1, Address: w.Address()}}}
+	missing.ID = missing.Hash()
+	for _, tx := range []*Transaction{missing, valid} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	if err := bc.MineBlock(pool, 10); err != nil {
+		t.Fatalf("MineBlock: %v", err)
+	}
+	if pool.Len() != 0 {
+		t.Fatalf("pool holds %d transactions after mining, want 0", pool.Len())
+	}
+	if txs := tipBlock(t, bc).Transactions; len(txs) != 2 || string(txs[1].ID) != string(valid.ID) {
+		t.Fatalf("mined block holds %d transactions, want the coinbase and the valid spend", len(txs))
+	}
+}
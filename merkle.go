@@ -0,0 +1,107 @@
+// Package main implements Merkle trees over block transactions
+package main
+
+import (
+	"bytes"         // for comparing and combining hashes
+	"crypto/sha256" // for hashing
+	"fmt"           // for error messages
+)
+
+// MerkleTree commits to a list of data items with a single root hash
+type MerkleTree struct {
+	levels [][][]byte // hashes at each level, leaves first and root last
+}
+
+// NewMerkleTree builds a Merkle tree over the given data items. Levels with
+// an odd number of nodes pair the last node with itself.
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var leaves [][]byte
+	for _, item := range data {
+		hash := sha256.Sum256(item)
+		leaves = append(leaves, hash[:])
+	}
+
+	// An empty tree has a single root: the hash of nothing
+	if len(leaves) == 0 {
+		hash := sha256.Sum256([]byte{})
+		leaves = append(leaves, hash[:])
+	}
+
+	tree := &MerkleTree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, hashPair(level[i], right))
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+
+	return tree
+}
+
+// MerkleStep is one sibling on the path from a leaf to the root
+type MerkleStep struct {
+	Hash []byte // the sibling's hash
+	Left bool   // whether the sibling is the left node of the pair
+}
+
+// RootHash returns the hash at the top of the tree
+func (t *MerkleTree) RootHash() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the siblings on the path from leaf to the root, which
+// VerifyMerkleProof uses to show leaf is part of the tree
+func (t *MerkleTree) Proof(leaf []byte) ([]MerkleStep, error) {
+	leafHash := sha256.Sum256(leaf)
+
+	index := -1
+	for i, hash := range t.levels[0] {
+		if bytes.Equal(hash, leafHash[:]) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("leaf %x not in tree", leafHash)
+	}
+
+	var proof []MerkleStep
+	for _, level := range t.levels[:len(t.levels)-1] {
+		// The last node of an odd level is its own sibling
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof = append(proof, MerkleStep{Hash: level[sibling], Left: sibling < index})
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that proof connects leaf to root
+func VerifyMerkleProof(root, leaf []byte, proof []MerkleStep) bool {
+	hash := sha256.Sum256(leaf)
+	current := hash[:]
+	for _, step := range proof {
+		if step.Left {
+			current = hashPair(step.Hash, current)
+		} else {
+			current = hashPair(current, step.Hash)
+		}
+	}
+	return bytes.Equal(current, root)
+}
+
+// hashPair hashes two sibling nodes into their parent. The order matters,
+// so the root commits to the order of the leaves.
+func hashPair(left, right []byte) []byte {
+	hash := sha256.Sum256(bytes.Join([][]byte{left, right}, []byte{}))
+	return hash[:]
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"         // for comparing hashes
+	"crypto/sha256" // for computing expected roots
+	"fmt"           // for naming leaves
+	"testing"       // for the test harness
+)
+
+// testHash hashes the concatenation of parts
+func testHash(parts ...[]byte) []byte {
+	hash := sha256.Sum256(bytes.Join(parts, nil))
+	return hash[:]
+}
+
+func TestMerkleRootKnownValues(t *testing.T) {
+	a, b, c, d := []byte("a"), []byte("b"), []byte("c"), []byte("d")
+	ha, hb, hc, hd := testHash(a), testHash(b), testHash(c), testHash(d)
+
+	tests := []struct {
+		name string
+		data [][]byte
+		want []byte
+	}{
+		{"one leaf", [][]byte{a}, ha},
+		{"two leaves", [][]byte{a, b}, testHash(ha, hb)},
+		// The odd leaf is paired with itself
+		{"three leaves", [][]byte{a, b, c}, testHash(testHash(ha, hb), testHash(hc, hc))},
+		{"four leaves", [][]byte{a, b, c, d}, testHash(testHash(ha, hb), testHash(hc, hd))},
+	}
+	for _, tt := range tests {
+		if got := NewMerkleTree(tt.data).RootHash(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: root = %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMerkleRootDependsOnOrder(t *testing.T) {
+	a := NewTransaction(nil, []TXOutput{{Value: 1, Address: []byte("a")}})
+	b := NewTransaction(nil, []TXOutput{{Value: 2, Address: []byte("b")}})
+
+	if bytes.Equal(HashTransactions([]*Transaction{a, b}), HashTransactions([]*Transaction{b, a})) {
+		t.Fatal("swapping two transactions did not change the Merkle root")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 7; size++ {
+		var data [][]byte
+		for i := 0; i < size; i++ {
+			data = append(data, []byte(fmt.Sprintf("leaf %d", i)))
+		}
+		tree := NewMerkleTree(data)
+		root := tree.RootHash()
+
+		for _, leaf := range data {
+			proof, err := tree.Proof(leaf)
+			if err != nil {
+				t.Fatalf("size %d: Proof(%s): %v", size, leaf, err)
+			}
+			if !VerifyMerkleProof(root, leaf, proof) {
+				t.Errorf("size %d: proof of %s does not verify", size, leaf)
+			}
+
+			// Flipping a side must break the proof, unless the node was
+			// paired with itself
+			for i := range proof {
+				if bytes.Equal(proof[i].Hash, proofNode(tree, leaf, i)) {
+					continue
+				}
+				flipped := append([]MerkleStep(nil), proof...)
+				flipped[i].Left = !flipped[i].Left
+				if VerifyMerkleProof(root, leaf, flipped) {
+					t.Errorf("size %d: proof of %s verifies with step %d flipped", size, leaf, i)
+				}
+			}
+		}
+	}
+
+	if _, err := NewMerkleTree([][]byte{[]byte("a")}).Proof([]byte("missing")); err == nil {
+		t.Fatal("Proof of a missing leaf succeeded")
+	}
+}
+
+// proofNode returns the hash on the path from leaf to the root at level
+func proofNode(tree *MerkleTree, leaf []byte, level int) []byte {
+	hash := testHash(leaf)
+	index := 0
+	for i, h := range tree.levels[0] {
+		if bytes.Equal(h, hash) {
+			index = i
+			break
+		}
+	}
+	return tree.levels[level][index>>level]
+}
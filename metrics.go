@@ -0,0 +1,49 @@
+// Package main implements metrics export for the blockchain
+package main
+
+import (
+	"fmt" // for formatting metric lines
+	"io"  // for the metrics destination
+)
+
+// WriteMetrics writes current chain statistics to w in the Prometheus text
+// exposition format so they can be scraped directly
+func (bc *Blockchain) WriteMetrics(w io.Writer) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks := bc.mustChain()
+	height := len(blocks) - 1
+
+	// Average time between blocks over the whole chain
+	var avgBlockTime float64
+	if height > 0 {
+		elapsed := blocks[height].Timestamp - blocks[0].Timestamp
+		avgBlockTime = float64(elapsed) / float64(height)
+	}
+
+	mempoolSize := 0
+	if bc.mempool != nil {
+		mempoolSize = bc.mempool.Len()
+	}
+
+	// Validators of the consensus producing the next block; PoW has none
+	validatorCount := 0
+	next := &Block{Height: height + 1, PrevBlockHash: blocks[height].Hash}
+	if counter, ok := NewConsensus(bc.consensusAt(next.Height), next).(validatorCounter); ok {
+		validatorCount = counter.ValidatorCount()
+	}
+
+	writeGauge(w, "gochain_height", "Height of the chain tip.", float64(height))
+	writeGauge(w, "gochain_total_supply", "Total value of unspent outputs.", float64(bc.utxo.TotalValue()))
+	writeGauge(w, "gochain_mempool_size", "Transactions waiting in the mempool.", float64(mempoolSize))
+	writeGauge(w, "gochain_avg_block_time_seconds", "Average time between blocks.", avgBlockTime)
+	writeGauge(w, "gochain_validator_count", "Validators producing the next block.", float64(validatorCount))
+}
+
+// writeGauge writes a single gauge metric with its HELP and TYPE lines
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
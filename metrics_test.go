@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"   // for capturing metrics
+	"strconv" // for parsing metric values
+	"strings" // for splitting metric lines
+	"testing" // for the test harness
+)
+
+// readMetrics parses the samples written by WriteMetrics
+func readMetrics(t *testing.T, bc *Blockchain) map[string]float64 {
+	t.Helper()
+	var out bytes.Buffer
+	bc.WriteMetrics(&out)
+
+	metrics := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("malformed metric line %q", line)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("metric %s has unparseable value %q", name, value)
+		}
+		metrics[name] = v
+	}
+	return metrics
+}
+
+func TestWriteMetrics(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	pool := bc.NewMempool()
+	if err := pool.Add(spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 40, Address: w.Address()})); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	metrics := readMetrics(t, bc)
+	want := map[string]float64{
+		"gochain_height":          1,
+		"gochain_total_supply":    defaultInitialReward,
+		"gochain_mempool_size":    1,
+		"gochain_validator_count": 0,
+	}
+	for name, value := range want {
+		if got, ok := metrics[name]; !ok || got != value {
+			t.Errorf("%s = %v (present %t), want %v", name, got, ok, value)
+		}
+	}
+	if _, ok := metrics["gochain_avg_block_time_seconds"]; !ok {
+		t.Error("gochain_avg_block_time_seconds is missing")
+	}
+}
+
+func TestWriteMetricsCountsValidators(t *testing.T) {
+	bc := newTestChain(t, POS)
+	if got := readMetrics(t, bc)["gochain_validator_count"]; got != float64(len(createMockValidators())) {
+		t.Fatalf("gochain_validator_count = %v, want %d", got, len(createMockValidators()))
+	}
+}
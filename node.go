@@ -0,0 +1,398 @@
+// Package main implements a TCP node serving blocks to peers
+package main
+
+import (
+	"bytes"           // for building messages
+	"context"         // for producing blocks
+	"encoding/binary" // for length prefixes
+	"encoding/gob"    // for encoding message payloads
+	"errors"          // for sentinel errors
+	"fmt"             // for error messages
+	"io"              // for reading messages
+	"net"             // for TCP connections
+	"sync"            // for guarding peers and seen blocks
+	"time"            // for connection deadlines
+)
+
+const (
+	nodeVersion    = 1                // protocol version spoken by this node
+	commandLength  = 12               // bytes reserved for the command name
+	maxMessageSize = 32 << 20         // largest message accepted, in bytes
+	peerTimeout    = 10 * time.Second // how long a peer exchange may take
+	maxSeenBlocks  = 1024             // block hashes remembered to stop gossip loops
+)
+
+// ErrPeerNotFound is returned when a peer doesn't have a requested block
+var ErrPeerNotFound = errors.New("peer does not have block")
+
+// versionMsg is the payload of a version reply
+type versionMsg struct {
+	Version    int // protocol version
+	BestHeight int // height of the node's tip
+}
+
+// Node serves its chain to peers over TCP. Each connection carries one
+// request and its reply, framed as a 4-byte big-endian length followed by a
+// command name and a payload:
+//
+//	version           replies version with the protocol version and height
+//	getblocks         replies inv with the block hashes from genesis to tip
+//	getdata <hash>    replies block with the serialized block, or notfound
+//	newblock <block>  offers a new block, replies ok or rejected
+type Node struct {
+	mu        sync.Mutex      // guards peers, seen and seenOrder
+	bc        *Blockchain     // the chain served to peers
+	listener  net.Listener    // accepts peer connections once started
+	peers     []string        // addresses new blocks are broadcast to
+	seen      map[string]bool // hashes of blocks already broadcast or accepted
+	seenOrder []string        // keys of seen, oldest first
+}
+
+// NewNode creates a Node serving bc
+func NewNode(bc *Blockchain) *Node {
+	return &Node{bc: bc, seen: make(map[string]bool)}
+}
+
+// AddPeer registers a peer to broadcast new blocks to
+func (n *Node) AddPeer(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers = append(n.peers, addr)
+}
+
+// Start listens for peers on addr and serves them in the background
+func (n *Node) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n.listener = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // listener closed
+			}
+			go n.handleConn(conn)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the node listens on, once started
+func (n *Node) Addr() string {
+	if n.listener == nil {
+		return ""
+	}
+	return n.listener.Addr().String()
+}
+
+// Close stops accepting peer connections
+func (n *Node) Close() error {
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Close()
+}
+
+// handleConn answers a single request from a peer
+func (n *Node) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	command, payload, err := readMessage(conn)
+	if err != nil {
+		n.bc.Logger().Printf("Bad message from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	reply, replyPayload, err := n.handle(command, payload)
+	if err != nil {
+		n.bc.Logger().Printf("Failed %s from %s: %v", command, conn.RemoteAddr(), err)
+		return
+	}
+	writeMessage(conn, reply, replyPayload)
+}
+
+// handle returns the reply to a request
+func (n *Node) handle(command string, payload []byte) (string, []byte, error) {
+	switch command {
+	case "version":
+		height, err := n.bc.Height()
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := gobEncode(versionMsg{Version: nodeVersion, BestHeight: height})
+		return "version", data, err
+
+	case "getblocks":
+		blocks, err := n.bc.Blocks()
+		if err != nil {
+			return "", nil, err
+		}
+		hashes := make([][]byte, len(blocks))
+		for i, block := range blocks {
+			hashes[i] = block.Hash
+		}
+		data, err := gobEncode(hashes)
+		return "inv", data, err
+
+	case "getdata":
+		block, err := n.bc.GetBlock(payload)
+		if errors.Is(err, ErrBlockNotFound) {
+			return "notfound", payload, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := block.Serialize()
+		return "block", data, err
+
+	case "newblock":
+		block, err := DeserializeBlock(payload)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// Ignore blocks we've already relayed, so gossip doesn't loop
+		n.mu.Lock()
+		seen := n.seen[string(block.Hash)]
+		n.mu.Unlock()
+		if seen {
+			return "ok", nil, nil
+		}
+
+		// Only accepted blocks are remembered, so a block rejected for now,
+		// such as one arriving before its parent, can be offered again
+		if err := n.bc.AcceptBlock(block); err != nil {
+			n.bc.Logger().Printf("Rejected broadcast block %x: %v", block.Hash, err)
+			return "rejected", nil, nil
+		}
+		n.markSeen(block.Hash)
+		go n.relay(block)
+		return "ok", nil, nil
+
+	default:
+		return "", nil, fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// request sends a request to the peer at peerAddr and returns its reply
+func (n *Node) request(peerAddr, command string, payload []byte) (string, []byte, error) {
+	conn, err := net.DialTimeout("tcp", peerAddr, peerTimeout)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	if err := writeMessage(conn, command, payload); err != nil {
+		return "", nil, err
+	}
+	return readMessage(conn)
+}
+
+// PeerVersion returns the protocol version and tip height of the peer
+func (n *Node) PeerVersion(peerAddr string) (version, bestHeight int, err error) {
+	reply, payload, err := n.request(peerAddr, "version", nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if reply != "version" {
+		return 0, 0, fmt.Errorf("unexpected reply %q to version", reply)
+	}
+
+	var msg versionMsg
+	if err := gobDecode(payload, &msg); err != nil {
+		return 0, 0, err
+	}
+	return msg.Version, msg.BestHeight, nil
+}
+
+// FetchInventory returns the hashes of the peer's blocks from genesis to tip
+func (n *Node) FetchInventory(peerAddr string) ([][]byte, error) {
+	reply, payload, err := n.request(peerAddr, "getblocks", nil)
+	if err != nil {
+		return nil, err
+	}
+	if reply != "inv" {
+		return nil, fmt.Errorf("unexpected reply %q to getblocks", reply)
+	}
+
+	var hashes [][]byte
+	if err := gobDecode(payload, &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// FetchBlock downloads the block with the given hash from the peer
+func (n *Node) FetchBlock(peerAddr string, hash []byte) (*Block, error) {
+	reply, payload, err := n.request(peerAddr, "getdata", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply {
+	case "block":
+		block, err := DeserializeBlock(payload)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(block.Hash, hash) {
+			return nil, fmt.Errorf("peer sent block %x, want %x", block.Hash, hash)
+		}
+		return block, nil
+	case "notfound":
+		return nil, fmt.Errorf("%w: %x", ErrPeerNotFound, hash)
+	default:
+		return nil, fmt.Errorf("unexpected reply %q to getdata", reply)
+	}
+}
+
+// AddBlock adds a block with the given transactions to the chain and
+// broadcasts it to every peer
+func (n *Node) AddBlock(transactions []*Transaction) error {
+	block, err := n.bc.addBlock(context.Background(), transactions)
+	if err != nil {
+		return err
+	}
+
+	n.Broadcast(block)
+	return nil
+}
+
+// Broadcast pushes block to every peer. Peers that accept it relay it to
+// their own peers.
+func (n *Node) Broadcast(block *Block) {
+	n.markSeen(block.Hash)
+	n.relay(block)
+}
+
+// markSeen remembers that the block with hash was relayed, forgetting the
+// oldest hash once maxSeenBlocks are remembered
+func (n *Node) markSeen(hash []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := string(hash)
+	if n.seen[key] {
+		return
+	}
+	n.seen[key] = true
+	n.seenOrder = append(n.seenOrder, key)
+	if len(n.seenOrder) > maxSeenBlocks {
+		delete(n.seen, n.seenOrder[0])
+		n.seenOrder = n.seenOrder[1:]
+	}
+}
+
+// relay sends block to every peer, logging failures
+func (n *Node) relay(block *Block) {
+	data, err := block.Serialize()
+	if err != nil {
+		n.bc.Logger().Printf("Failed to serialize block %x: %v", block.Hash, err)
+		return
+	}
+
+	n.mu.Lock()
+	peers := append([]string(nil), n.peers...)
+	n.mu.Unlock()
+
+	for _, peer := range peers {
+		reply, _, err := n.request(peer, "newblock", data)
+		if err != nil {
+			n.bc.Logger().Printf("Failed to send block %x to %s: %v", block.Hash, peer, err)
+			continue
+		}
+		if reply != "ok" {
+			n.bc.Logger().Printf("Peer %s rejected block %x", peer, block.Hash)
+		}
+	}
+}
+
+// SyncFrom downloads the blocks the peer has beyond the local chain and
+// appends them in order, validating each. Blocks that don't build on the
+// local tip, such as those of a competing fork, are rejected.
+func (n *Node) SyncFrom(peerAddr string) error {
+	hashes, err := n.FetchInventory(peerAddr)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err := n.bc.GetBlock(hash)
+		if err == nil {
+			continue // already have it
+		}
+		if !errors.Is(err, ErrBlockNotFound) {
+			return err
+		}
+
+		block, err := n.FetchBlock(peerAddr, hash)
+		if err != nil {
+			return err
+		}
+
+		if err := n.bc.AcceptBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMessage writes a length-prefixed message
+func writeMessage(w io.Writer, command string, payload []byte) error {
+	if len(command) > commandLength {
+		return fmt.Errorf("command %q is too long", command)
+	}
+
+	// The command name is zero-padded to a fixed width
+	body := make([]byte, commandLength, commandLength+len(payload))
+	copy(body, command)
+	body = append(body, payload...)
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// readMessage reads a length-prefixed message
+func readMessage(r io.Reader) (string, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size < commandLength || size > maxMessageSize {
+		return "", nil, fmt.Errorf("invalid message size %d", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", nil, err
+	}
+
+	command := string(bytes.TrimRight(body[:commandLength], "\x00"))
+	return command, body[commandLength:], nil
+}
+
+// gobEncode encodes v with gob
+func gobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gobDecode decodes gob data into v
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
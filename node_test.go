@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"   // for comparing hashes
+	"fmt"     // for block hashes
+	"log"     // for capturing node logs
+	"net"     // for sending malformed messages
+	"strings" // for inspecting logs
+	"sync"    // for swapping loggers concurrently
+	"testing" // for the test harness
+	"time"    // for waiting on gossip
+)
+
+// startTestNode starts a node serving bc on a free local port
+func startTestNode(t *testing.T, bc *Blockchain) *Node {
+	t.Helper()
+	node := NewNode(bc)
+	if err := node.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+func TestSyncFromRejectsOtherConsensus(t *testing.T) {
+	seed := newTestChain(t, POW)
+	fresh := forkTestChain(t, seed)
+	forceTip(t, seed, forgeAuthorityBlock(t, seed))
+
+	seedNode := startTestNode(t, seed)
+	freshNode := startTestNode(t, fresh)
+	if err := freshNode.SyncFrom(seedNode.Addr()); err == nil {
+		t.Fatal("SyncFrom accepted a PoA block on a PoW chain")
+	}
+	if height := tipBlock(t, fresh).Height; height != 0 {
+		t.Fatalf("height after rejected sync = %d, want 0", height)
+	}
+}
+
+func TestBroadcastRejectsOtherConsensus(t *testing.T) {
+	sender := newTestChain(t, POW)
+	receiver := forkTestChain(t, sender)
+
+	senderNode := startTestNode(t, sender)
+	receiverNode := startTestNode(t, receiver)
+	senderNode.AddPeer(receiverNode.Addr())
+
+	block := forgeAuthorityBlock(t, receiver)
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _, err := senderNode.request(receiverNode.Addr(), "newblock", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "rejected" {
+		t.Fatalf("reply to forged block = %q, want rejected", reply)
+	}
+	if height := tipBlock(t, receiver).Height; height != 0 {
+		t.Fatalf("height after rejected broadcast = %d, want 0", height)
+	}
+}
+
+// offerBlock sends block to node as a peer broadcasting it would, returning
+// the reply
+func offerBlock(t *testing.T, node *Node, block *Block) string {
+	t.Helper()
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _, err := node.request(node.Addr(), "newblock", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestBroadcastPropagates(t *testing.T) {
+	a := newTestChain(t, POW)
+	b, c := forkTestChain(t, a), forkTestChain(t, a)
+	nodeA, nodeB, nodeC := startTestNode(t, a), startTestNode(t, b), startTestNode(t, c)
+
+	// A line A -> B -> C, with C pointing back at A to form a loop
+	nodeA.AddPeer(nodeB.Addr())
+	nodeB.AddPeer(nodeC.Addr())
+	nodeC.AddPeer(nodeA.Addr())
+
+	if err := nodeA.AddBlock(nil); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	want := tipBlock(t, a).Hash
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, bc := range []*Blockchain{b, c} {
+		for string(tipBlock(t, bc).Hash) != string(want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("block %x did not propagate", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRejectedBlockCanBeOfferedAgain(t *testing.T) {
+	sender := newTestChain(t, POW)
+	receiver := forkTestChain(t, sender)
+	addTestBlocks(t, sender, 2)
+	blocks, err := sender.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := startTestNode(t, receiver)
+
+	// The child arrives before its parent and is rejected, but not forgotten
+	if reply := offerBlock(t, node, blocks[2]); reply != "rejected" {
+		t.Fatalf("reply to orphan block = %q, want rejected", reply)
+	}
+	if reply := offerBlock(t, node, blocks[1]); reply != "ok" {
+		t.Fatalf("reply to parent block = %q, want ok", reply)
+	}
+	if reply := offerBlock(t, node, blocks[2]); reply != "ok" {
+		t.Fatalf("reply to child block offered again = %q, want ok", reply)
+	}
+	if height := tipBlock(t, receiver).Height; height != 2 {
+		t.Fatalf("height after offering the child again = %d, want 2", height)
+	}
+}
+
+func TestSeenBlocksAreBounded(t *testing.T) {
+	node := NewNode(newTestChain(t, POW))
+	for i := 0; i < maxSeenBlocks+10; i++ {
+		node.markSeen([]byte(fmt.Sprintf("block %d", i)))
+	}
+
+	if len(node.seen) != maxSeenBlocks || len(node.seenOrder) != maxSeenBlocks {
+		t.Fatalf("node remembers %d blocks (%d in order), want %d", len(node.seen), len(node.seenOrder), maxSeenBlocks)
+	}
+	if node.seen["block 0"] {
+		t.Fatal("oldest block was not forgotten")
+	}
+	if !node.seen[fmt.Sprintf("block %d", maxSeenBlocks+9)] {
+		t.Fatal("newest block was forgotten")
+	}
+}
+
+func TestSyncFromCopiesChain(t *testing.T) {
+	seed := newTestChain(t, POW)
+	fresh := forkTestChain(t, seed)
+	addTestBlocks(t, seed, 3)
+
+	seedNode := startTestNode(t, seed)
+	freshNode := startTestNode(t, fresh)
+	if err := freshNode.SyncFrom(seedNode.Addr()); err != nil {
+		t.Fatalf("SyncFrom: %v", err)
+	}
+
+	want, err := seed.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fresh.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("synced %d blocks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].Hash, want[i].Hash) {
+			t.Fatalf("block %d = %x, want %x", i, got[i].Hash, want[i].Hash)
+		}
+	}
+	if err := fresh.Validate(); err != nil {
+		t.Fatalf("Validate after sync: %v", err)
+	}
+
+	// Syncing again finds nothing new
+	if err := freshNode.SyncFrom(seedNode.Addr()); err != nil {
+		t.Fatalf("second SyncFrom: %v", err)
+	}
+	if height := tipBlock(t, fresh).Height; height != 3 {
+		t.Fatalf("height after second sync = %d, want 3", height)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes and reads
+type lockedBuffer struct {
+	mu  sync.Mutex   // guards buf
+	buf bytes.Buffer // the written bytes
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestNodeLogsWhileLoggerChanges(t *testing.T) {
+	bc := newTestChain(t, POA)
+	node := startTestNode(t, bc)
+
+	var out lockedBuffer
+	logger := log.New(&out, "", 0)
+	bc.SetLogger(logger)
+
+	// Keep setting the logger while the node logs, which the race detector
+	// flags unless both sides synchronize on the chain
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bc.SetLogger(logger)
+			}
+		}
+	}()
+
+	const conns = 20
+	for i := 0; i < conns; i++ {
+		// Closing without a message makes the node log a bad message
+		conn, err := net.Dial("tcp", node.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(out.String(), "Bad message from") < conns && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	if n := strings.Count(out.String(), "Bad message from"); n != conns {
+		t.Fatalf("node logged %d bad messages, want %d:\n%s", n, conns, out.String())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"   // for comparing authorities
+	"context" // for running consensus
+	"strings" // for matching error messages
+	"testing" // for the test harness
+	"time"    // for block timestamps
+)
+
+func TestPoARotatesAuthorities(t *testing.T) {
+	authorities := createMockAuthorities()
+	for height := 0; height < 2*len(authorities); height++ {
+		block := &Block{Height: height, Consensus: POA}
+		validatorID, hash, err := NewProofOfAuthority(block).Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run at height %d: %v", height, err)
+		}
+		if want := authorities[height%len(authorities)]; !bytes.Equal(validatorID, want) {
+			t.Fatalf("Run at height %d signed as %s, want %s", height, validatorID, want)
+		}
+		block.ValidatorID, block.Hash = validatorID, hash
+
+		if valid, err := NewProofOfAuthority(block).Validate(); !valid || err != nil {
+			t.Fatalf("Validate of in-turn block at height %d = %v, %v", height, valid, err)
+		}
+	}
+}
+
+func TestPoARejectsOutOfTurnSigner(t *testing.T) {
+	authorities := createMockAuthorities()
+	for height := 0; height < len(authorities); height++ {
+		for i, authority := range authorities {
+			if i == height%len(authorities) {
+				continue
+			}
+			block := &Block{Height: height, Consensus: POA, ValidatorID: authority}
+			block.Hash = block.ComputeHash()
+			if valid, err := NewProofOfAuthority(block).Validate(); valid || err != nil {
+				t.Errorf("Validate of %s signing at height %d = %v, %v, want false", authority, height, valid, err)
+			}
+		}
+	}
+
+	// A signer outside the set is never in turn
+	block := &Block{Height: 0, Consensus: POA, ValidatorID: []byte("intruder")}
+	if valid, _ := NewProofOfAuthority(block).Validate(); valid {
+		t.Error("Validate accepted a signer that is not an authority")
+	}
+}
+
+func TestChainRejectsOutOfTurnAuthorityBlock(t *testing.T) {
+	bc := newTestChain(t, POA)
+	addTestBlocks(t, bc, 1)
+	tip := tipBlock(t, bc)
+
+	// Height 2 belongs to authority3, so authority1 is out of turn
+	block := &Block{
+		Version:       BlockVersion,
+		Timestamp:     time.Now().Unix(),
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 1,
+		ValidatorID:   []byte("authority1"),
+		TargetBits:    nextTestDifficulty(t, bc),
+		Consensus:     POA,
+	}
+	block.Hash = block.ComputeHash()
+
+	err := bc.AcceptBlock(block)
+	if err == nil || !strings.Contains(err.Error(), "consensus validation failed") {
+		t.Fatalf("AcceptBlock of an out-of-turn block = %v, want consensus failure", err)
+	}
+	if got := tipBlock(t, bc); !bytes.Equal(got.Hash, tip.Hash) {
+		t.Fatalf("tip moved to %x after a rejected block", got.Hash)
+	}
+}
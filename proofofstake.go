@@ -2,134 +2,425 @@
 package main
 
 import (
-	"bytes"         // for comparing and combining byte slices
-	"crypto/sha256" // for hashing
-
-	// for converting to binary
-	"fmt"       // for printing
-	"math/big"  // for working with large integers
-	"math/rand" // for random number generation
-	"time"      // for timestamps
+	"bytes"          // for comparing addresses
+	"context"        // for cancelling consensus
+	"crypto/ecdsa"   // for validator public keys
+	"crypto/ed25519" // for signing forged blocks
+	"crypto/sha256"  // for hashing
+	"encoding/hex"   // for reading validator keys
+	"encoding/json"  // for reading validator files
+	"errors"         // for error values
+	"fmt"            // for printing
+	"math/big"       // for working with large integers
+	"os"             // for reading validator files
+	"sort"           // for ordering validators by stake
 )
 
 // Validator represents a participant in the PoS system
 type Validator struct {
-	Address []byte // validator's address
-	Stake   uint64 // amount of coins staked
-	Balance uint64 // total balance including stake
+	Address    []byte             // validator's address
+	Stake      uint64             // amount of coins staked
+	Balance    uint64             // total balance including stake
+	PublicKey  ed25519.PublicKey  // verifies the validator's block signatures
+	SigningKey ed25519.PrivateKey // signs forged blocks, nil if run elsewhere
 }
 
 // ProofOfStake represents a proof-of-stake system
 type ProofOfStake struct {
-	block      *Block       // pointer to the block being validated
-	validators []*Validator // list of validators
-	threshold  *big.Int     // threshold for valid blocks (similar to PoW target)
+	block             *Block       // pointer to the block being validated
+	validators        []*Validator // list of validators
+	nakamotoThreshold float64      // share of total stake NakamotoCoefficient must exceed
+	minStake          uint64       // stake below which validators are ignored
+	prevValidator     []byte       // producer of the preceding block, if any
+	blockReward       uint64       // amount credited to the validator forging a block
+	logger            Logger       // receives forging status messages
 }
 
-// NewProofOfStake builds and returns a ProofOfStake
-func NewProofOfStake(b *Block) *ProofOfStake {
-	// In a real implementation, validators would be loaded from a persistent store
-	// Here we create some mock validators for demonstration
-	validators := createMockValidators()
-
-	// Set a threshold for valid blocks (simplified version)
-	threshold := big.NewInt(1)
-	threshold.Lsh(threshold, 255) // Very easy threshold for demonstration
-
+// NewProofOfStake builds and returns a ProofOfStake selecting among the given
+// validators. With none, Run and Validate fail until validators are added.
+func NewProofOfStake(b *Block, validators ...*Validator) *ProofOfStake {
 	pos := &ProofOfStake{
-		block:      b,
-		validators: validators,
-		threshold:  threshold,
+		block:             b,
+		validators:        validators,
+		nakamotoThreshold: 0.5,
+		logger:            nopLogger{},
 	}
 	return pos
 }
 
-// createMockValidators creates test validators
+// SetLogger routes forging status messages to logger
+func (pos *ProofOfStake) SetLogger(logger Logger) {
+	pos.logger = logger
+}
+
+// ValidatorAddressFromPubKey derives a validator address from a public key,
+// identical to the address of a Wallet holding the same key. It returns nil
+// if the key is invalid.
+func ValidatorAddressFromPubKey(pub *ecdsa.PublicKey) []byte {
+	pubKey, err := pub.Bytes()
+	if err != nil {
+		return nil
+	}
+	return addressFromPubKey(pubKey)
+}
+
+// validatorJSON is the on-disk form of a Validator
+type validatorJSON struct {
+	Address   string `json:"address"`
+	Stake     uint64 `json:"stake"`
+	Balance   uint64 `json:"balance"`
+	PublicKey string `json:"publicKey"` // hex-encoded ed25519 public key
+}
+
+// LoadValidators reads a JSON array of validators from path, rejecting an
+// empty array, duplicate addresses, validators without stake and malformed
+// public keys. Signing keys are never read from the file.
+func LoadValidators(path string) ([]*Validator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []validatorJSON
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode validators: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no validators in %s", path)
+	}
+
+	seen := make(map[string]bool, len(entries))
+	validators := make([]*Validator, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Address == "" {
+			return nil, fmt.Errorf("validator %d has no address", i)
+		}
+		if entry.Stake == 0 {
+			return nil, fmt.Errorf("validator %s has no stake", entry.Address)
+		}
+		if seen[entry.Address] {
+			return nil, fmt.Errorf("validator %s is listed more than once", entry.Address)
+		}
+		seen[entry.Address] = true
+
+		publicKey, err := hex.DecodeString(entry.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("validator %s has an invalid public key", entry.Address)
+		}
+
+		validators = append(validators, &Validator{
+			Address:   []byte(entry.Address),
+			Stake:     entry.Stake,
+			Balance:   entry.Balance,
+			PublicKey: publicKey,
+		})
+	}
+
+	return validators, nil
+}
+
+// createMockValidators creates test validators. Their keys are public, so
+// they are only used where a caller asks for them explicitly.
 func createMockValidators() []*Validator {
-	return []*Validator{
+	validators := []*Validator{
 		{Address: []byte("validator1"), Stake: 1000, Balance: 5000},
 		{Address: []byte("validator2"), Stake: 2000, Balance: 8000},
 		{Address: []byte("validator3"), Stake: 3000, Balance: 10000},
 	}
+
+	// Derive keys from the addresses so every instance agrees on them.
+	// This is only acceptable for demonstration: anyone can forge them.
+	for _, v := range validators {
+		seed := sha256.Sum256(v.Address)
+		v.SigningKey = ed25519.NewKeyFromSeed(seed[:])
+		v.PublicKey = v.SigningKey.Public().(ed25519.PublicKey)
+	}
+	return validators
 }
 
-// selectValidator chooses a validator based on their stake
-func (pos *ProofOfStake) selectValidator() *Validator {
-	// Calculate total stake
-	var totalStake uint64
+// AddValidator adds a validator to the set used for selection
+func (pos *ProofOfStake) AddValidator(v *Validator) error {
+	if v.Stake == 0 {
+		return fmt.Errorf("validator %s has no stake", v.Address)
+	}
+	if pos.findValidator(v.Address) != -1 {
+		return fmt.Errorf("validator %s already exists", v.Address)
+	}
+
+	pos.validators = append(pos.validators, v)
+	return nil
+}
+
+// RemoveValidator removes the validator with the given address
+func (pos *ProofOfStake) RemoveValidator(address []byte) error {
+	i := pos.findValidator(address)
+	if i == -1 {
+		return fmt.Errorf("validator %s not found", address)
+	}
+
+	pos.validators = append(pos.validators[:i], pos.validators[i+1:]...)
+	return nil
+}
+
+// Slash penalizes a misbehaving validator by burning up to penalty of its
+// stake, removing it entirely once no stake is left
+func (pos *ProofOfStake) Slash(address []byte, penalty uint64) error {
+	i := pos.findValidator(address)
+	if i == -1 {
+		return fmt.Errorf("validator %s not found", address)
+	}
+
+	// Only staked coins can be slashed
+	v := pos.validators[i]
+	if penalty > v.Stake {
+		penalty = v.Stake
+	}
+	v.Stake -= penalty
+	if penalty > v.Balance {
+		v.Balance = 0
+	} else {
+		v.Balance -= penalty
+	}
+
+	if v.Stake == 0 {
+		pos.validators = append(pos.validators[:i], pos.validators[i+1:]...)
+	}
+	return nil
+}
+
+// DetectEquivocation reports the validator that forged both a and b if they
+// are different blocks built on the same parent
+func DetectEquivocation(a, b *Block) ([]byte, bool) {
+	if !bytes.Equal(a.PrevBlockHash, b.PrevBlockHash) || bytes.Equal(a.Hash, b.Hash) {
+		return nil, false
+	}
+	if !bytes.Equal(a.ValidatorID, b.ValidatorID) {
+		return nil, false
+	}
+	return a.ValidatorID, true
+}
+
+// findValidator returns the index of the validator with the given address, or -1
+func (pos *ProofOfStake) findValidator(address []byte) int {
+	for i, v := range pos.validators {
+		if bytes.Equal(v.Address, address) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetMinStake excludes validators staking less than minStake from selection
+// and validation
+func (pos *ProofOfStake) SetMinStake(minStake uint64) {
+	pos.minStake = minStake
+}
+
+// SetNakamotoThreshold sets the share of total stake, such as 0.5 or 0.67,
+// that the validators counted by NakamotoCoefficient must exceed
+func (pos *ProofOfStake) SetNakamotoThreshold(threshold float64) {
+	pos.nakamotoThreshold = threshold
+}
+
+// SetBlockReward sets the amount credited to the balance of each block's
+// forging validator. Run records it in the block's ValidatorReward.
+func (pos *ProofOfStake) SetBlockReward(reward uint64) {
+	pos.blockReward = reward
+}
+
+// SetPreviousValidator records who produced the preceding block, so the same
+// validator cannot forge two blocks in a row
+func (pos *ProofOfStake) SetPreviousValidator(validatorID []byte) {
+	pos.prevValidator = validatorID
+}
+
+// ValidatorCount returns how many validators meet the minimum stake
+func (pos *ProofOfStake) ValidatorCount() int {
+	return len(pos.eligibleValidators())
+}
+
+// eligibleValidators returns the validators meeting the minimum stake
+func (pos *ProofOfStake) eligibleValidators() []*Validator {
+	var eligible []*Validator
 	for _, v := range pos.validators {
+		if v.Stake >= pos.minStake {
+			eligible = append(eligible, v)
+		}
+	}
+	return eligible
+}
+
+// totalStake sums the stake of the given validators
+func totalStake(validators []*Validator) (uint64, error) {
+	var totalStake uint64
+	for _, v := range validators {
+		if totalStake+v.Stake < totalStake {
+			return 0, errors.New("total stake overflows uint64")
+		}
 		totalStake += v.Stake
 	}
+	return totalStake, nil
+}
 
-	// Random number between 0 and totalStake
-	rand.Seed(time.Now().UnixNano())
-	selection := rand.Uint64() % totalStake
+// selectValidator deterministically chooses a validator for the block,
+// weighted by stake. It also returns the total stake selected from.
+func (pos *ProofOfStake) selectValidator() (*Validator, uint64, error) {
+	validators := pos.eligibleValidators()
 
-	// Select validator based on stake weight
+	// Skip whoever forged the previous block, unless nobody else could
+	if len(validators) > 1 && pos.prevValidator != nil {
+		others := make([]*Validator, 0, len(validators))
+		for _, v := range validators {
+			if !bytes.Equal(v.Address, pos.prevValidator) {
+				others = append(others, v)
+			}
+		}
+		if len(others) > 0 {
+			validators = others
+		}
+	}
+
+	// Calculate total stake
+	totalStake, err := totalStake(validators)
+	if err != nil {
+		return nil, 0, err
+	}
+	if totalStake == 0 {
+		return nil, 0, errors.New("no validators with stake")
+	}
+
+	// Derive a number in [0, totalStake) from the chain position, so every
+	// node selects the same validator for the block
+	seed := sha256.Sum256(bytes.Join([][]byte{pos.block.PrevBlockHash, IntToHex(int64(pos.block.Height))}, []byte{}))
+	var seedInt big.Int
+	seedInt.SetBytes(seed[:])
+	selection := seedInt.Mod(&seedInt, new(big.Int).SetUint64(totalStake)).Uint64()
+
+	// Select validator based on stake weight. Each validator owns the
+	// half-open range [accumulator, accumulator+Stake), so the ranges
+	// cover [0, totalStake) exactly and match each stake's weight.
 	var accumulator uint64
-	for _, v := range pos.validators {
+	for _, v := range validators {
 		accumulator += v.Stake
-		if selection <= accumulator {
-			return v
+		if selection < accumulator {
+			return v, totalStake, nil
 		}
 	}
 
-	return pos.validators[0] // fallback
+	return validators[0], totalStake, nil // fallback
 }
 
-// prepareData combines block fields for hashing
+// NakamotoCoefficient returns the smallest number of eligible validators
+// whose combined stake exceeds nakamotoThreshold of the total eligible
+// stake. Lower values mean more centralization.
+func (pos *ProofOfStake) NakamotoCoefficient() (int, error) {
+	validators := pos.eligibleValidators()
+	total, err := totalStake(validators)
+	if err != nil {
+		return 0, err
+	}
+
+	// Take the largest stakes first
+	stakes := make([]uint64, len(validators))
+	for i, v := range validators {
+		stakes[i] = v.Stake
+	}
+	sort.Slice(stakes, func(i, j int) bool { return stakes[i] > stakes[j] })
+
+	// No partial sum can overflow, as the total didn't
+	limit := float64(total) * pos.nakamotoThreshold
+	var accumulator uint64
+	for i, stake := range stakes {
+		accumulator += stake
+		if float64(accumulator) > limit {
+			return i + 1, nil
+		}
+	}
+
+	return len(stakes), nil
+}
+
+// prepareData combines block fields with the validator's address for hashing
 func (pos *ProofOfStake) prepareData(validator *Validator) []byte {
-	return bytes.Join(
-		[][]byte{
-			pos.block.PrevBlockHash,
-			pos.block.Data,
-			IntToHex(pos.block.Timestamp),
-			validator.Address,
-			IntToHex(int64(validator.Stake)),
-		},
-		[]byte{},
-	)
+	return pos.block.hashData(validator.Address)
 }
 
 // Run performs the proof-of-stake consensus
 // Returns validator address and resulting hash
-func (pos *ProofOfStake) Run() ([]byte, []byte) {
-	fmt.Printf("Selecting validator for new block...")
+func (pos *ProofOfStake) Run(ctx context.Context) ([]byte, []byte, error) {
+	// Selection is instant, so only check for cancellation up front
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	pos.logger.Printf("Selecting validator for new block...")
 
 	// Select validator based on stake
-	validator := pos.selectValidator()
+	validator, totalStake, err := pos.selectValidator()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if validator.SigningKey == nil {
+		return nil, nil, fmt.Errorf("validator %s has no signing key", validator.Address)
+	}
+
+	// Record the selection and reward in the block for auditing
+	pos.block.ValidatorStake = validator.Stake
+	pos.block.TotalStake = totalStake
+	pos.block.ValidatorReward = pos.blockReward
 
-	// Prepare and hash the block data
+	// Prepare, hash and sign the block data
 	data := pos.prepareData(validator)
 	hash := sha256.Sum256(data)
+	pos.block.Signature = ed25519.Sign(validator.SigningKey, data)
 
-	fmt.Printf("\nBlock forged by validator with stake: %d\n", validator.Stake)
+	// Reward the validator for forging the block
+	validator.Balance += pos.blockReward
 
-	return validator.Address, hash[:]
+	pos.logger.Printf("Block forged by validator with stake: %d, reward: %d", validator.Stake, pos.blockReward)
+
+	return validator.Address, hash[:], nil
 }
 
-// Validate verifies the proof-of-stake
-func (pos *ProofOfStake) Validate() bool {
-	// In a real implementation, we would:
-	// 1. Verify the validator's signature
-	// 2. Check if the validator has sufficient stake
-	// 3. Verify the validator hasn't forged another block recently
-	// 4. Check for double-spending
+// Validate verifies the proof-of-stake: the block must be forged and signed
+// by an eligible validator that didn't forge the previous block
+func (pos *ProofOfStake) Validate() (bool, error) {
+	validators := pos.eligibleValidators()
+	if len(validators) == 0 {
+		return false, errors.New("no validators meet the minimum stake")
+	}
 
-	// For demonstration, we'll do a simplified validation
-	for _, v := range pos.validators {
-		data := pos.prepareData(v)
-		hash := sha256.Sum256(data)
+	// A lone validator has to forge every block
+	if len(validators) > 1 && pos.prevValidator != nil && bytes.Equal(pos.block.ValidatorID, pos.prevValidator) {
+		return false, fmt.Errorf("validator %s forged the previous block", pos.block.ValidatorID)
+	}
 
-		// Convert hash to big integer
-		var hashInt big.Int
-		hashInt.SetBytes(hash[:])
+	// Selection is deterministic, so the producer must be the validator
+	// this node would have selected
+	validator, totalStake, err := pos.selectValidator()
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(validator.Address, pos.block.ValidatorID) {
+		return false, fmt.Errorf("block forged by %s, but %s was selected", pos.block.ValidatorID, validator.Address)
+	}
+	if pos.block.ValidatorStake != validator.Stake || pos.block.TotalStake != totalStake {
+		return false, fmt.Errorf("block records stake %d of %d, want %d of %d",
+			pos.block.ValidatorStake, pos.block.TotalStake, validator.Stake, totalStake)
+	}
 
-		// Check if hash is below threshold
-		if hashInt.Cmp(pos.threshold) == -1 {
-			return true
-		}
+	// The block must be signed by the validator's key
+	data := pos.prepareData(validator)
+	if len(pos.block.Signature) == 0 {
+		return false, errors.New("block is not signed")
+	}
+	if len(validator.PublicKey) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("validator %s has no public key", validator.Address)
+	}
+	if !ed25519.Verify(validator.PublicKey, data, pos.block.Signature) {
+		return false, fmt.Errorf("invalid signature from validator %s", validator.Address)
 	}
 
-	return false
+	return true, nil
 }
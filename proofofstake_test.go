@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"          // for comparing addresses
+	"context"        // for running consensus
+	"crypto/ecdsa"   // for invalid public keys
+	"crypto/ed25519" // for forging signatures
+	"encoding/hex"   // for encoding public keys
+	"math"           // for overflowing stakes
+	"os"             // for writing validator files
+	"path/filepath"  // for validator file paths
+	"strings"        // for matching error messages
+	"testing"        // for the test harness
+)
+
+// writeValidators writes contents to a validator file and returns its path
+func writeValidators(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "validators.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPoSWithoutValidatorsFails(t *testing.T) {
+	pos := NewProofOfStake(&Block{Height: 1})
+	if _, _, err := pos.Run(context.Background()); err == nil {
+		t.Fatal("Run with no validators succeeded")
+	}
+	if valid, err := pos.Validate(); valid || err == nil {
+		t.Fatalf("Validate with no validators = %v, %v, want error", valid, err)
+	}
+}
+
+func TestLoadValidators(t *testing.T) {
+	key := hex.EncodeToString(createMockValidators()[0].PublicKey)
+	path := writeValidators(t, `[
+		{"address": "alice", "stake": 100, "balance": 500, "publicKey": "`+key+`"},
+		{"address": "bob", "stake": 50, "balance": 50, "publicKey": "`+key+`"}
+	]`)
+
+	validators, err := LoadValidators(path)
+	if err != nil {
+		t.Fatalf("LoadValidators: %v", err)
+	}
+	if len(validators) != 2 || string(validators[0].Address) != "alice" || validators[0].Stake != 100 || validators[1].Balance != 50 {
+		t.Fatalf("LoadValidators read %+v", validators)
+	}
+	if validators[0].SigningKey != nil {
+		t.Fatal("LoadValidators set a signing key")
+	}
+}
+
+func TestLoadValidatorsRejectsBadFiles(t *testing.T) {
+	key := hex.EncodeToString(createMockValidators()[0].PublicKey)
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"malformed", `[{"address": `, "decode validators"},
+		{"empty", `[]`, "no validators"},
+		{"duplicate", `[{"address": "a", "stake": 1, "publicKey": "` + key + `"}, {"address": "a", "stake": 2, "publicKey": "` + key + `"}]`, "more than once"},
+		{"no stake", `[{"address": "a", "stake": 0, "publicKey": "` + key + `"}]`, "no stake"},
+		{"no address", `[{"stake": 1, "publicKey": "` + key + `"}]`, "no address"},
+		{"bad key", `[{"address": "a", "stake": 1, "publicKey": "zz"}]`, "invalid public key"},
+	}
+	for _, tt := range tests {
+		_, err := LoadValidators(writeValidators(t, tt.contents))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: LoadValidators = %v, want error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSlash(t *testing.T) {
+	pos := NewProofOfStake(&Block{}, createMockValidators()...)
+	first := pos.validators[0]
+	first.Balance = 100 // less than the 1000 staked
+
+	if err := pos.Slash([]byte("validator2"), 500); err != nil {
+		t.Fatalf("Slash: %v", err)
+	}
+	if v := pos.validators[1]; v.Stake != 1500 || v.Balance != 7500 {
+		t.Fatalf("after slashing 500: stake %d, balance %d, want 1500, 7500", v.Stake, v.Balance)
+	}
+
+	// The balance can't wrap below zero, and no stake left removes the validator
+	if err := pos.Slash([]byte("validator1"), 5000); err != nil {
+		t.Fatalf("Slash: %v", err)
+	}
+	if first.Stake != 0 || first.Balance != 0 {
+		t.Fatalf("after slashing everything: stake %d, balance %d, want 0, 0", first.Stake, first.Balance)
+	}
+	if pos.findValidator([]byte("validator1")) != -1 {
+		t.Fatal("validator with no stake left was not removed")
+	}
+	if err := pos.Slash([]byte("validator1"), 1); err == nil {
+		t.Fatal("Slash of a removed validator succeeded")
+	}
+}
+
+func TestBlockchainSlashIsAuditedAndPublished(t *testing.T) {
+	bc := newTestChain(t, POS)
+	log := &MemoryAuditLog{}
+	bc.SetAuditLog(log)
+	events := bc.Subscribe(ValidatorSlashed)
+	pos := NewProofOfStake(&Block{}, createMockValidators()...)
+
+	if err := bc.Slash(pos, []byte("validator3"), 100); err != nil {
+		t.Fatalf("Slash: %v", err)
+	}
+	if err := bc.Slash(pos, []byte("nobody"), 100); err == nil {
+		t.Fatal("Slash of an unknown validator succeeded")
+	}
+
+	entries := log.Entries()
+	if len(entries) != 1 || entries[0].Operation != "Slash" {
+		t.Fatalf("audit log holds %+v, want one Slash entry", entries)
+	}
+	select {
+	case event := <-events:
+		if string(event.Address) != "validator3" {
+			t.Fatalf("ValidatorSlashed for %s, want validator3", event.Address)
+		}
+	default:
+		t.Fatal("no ValidatorSlashed event was published")
+	}
+}
+
+func TestNakamotoCoefficient(t *testing.T) {
+	// One whale holds 60% of the stake
+	pos := NewProofOfStake(&Block{},
+		&Validator{Address: []byte("whale"), Stake: 600},
+		&Validator{Address: []byte("a"), Stake: 150},
+		&Validator{Address: []byte("b"), Stake: 150},
+		&Validator{Address: []byte("c"), Stake: 100},
+		&Validator{Address: []byte("dust"), Stake: 1},
+	)
+
+	tests := []struct {
+		threshold float64
+		minStake  uint64
+		want      int
+	}{
+		{0.5, 0, 1},
+		{0.67, 0, 2},
+		{0.85, 0, 3},
+		// 900 of 1001 is just short, but ignoring the dust validator
+		// leaves 900 of 1000
+		{0.8999, 0, 4},
+		{0.8999, 10, 3},
+	}
+	for _, tt := range tests {
+		pos.SetNakamotoThreshold(tt.threshold)
+		pos.SetMinStake(tt.minStake)
+		got, err := pos.NakamotoCoefficient()
+		if err != nil || got != tt.want {
+			t.Errorf("threshold %v, min stake %d: NakamotoCoefficient() = %d, %v, want %d", tt.threshold, tt.minStake, got, err, tt.want)
+		}
+	}
+}
+
+func TestNakamotoCoefficientOverflow(t *testing.T) {
+	pos := NewProofOfStake(&Block{},
+		&Validator{Address: []byte("a"), Stake: math.MaxUint64},
+		&Validator{Address: []byte("b"), Stake: 1},
+	)
+	if _, err := pos.NakamotoCoefficient(); err == nil {
+		t.Fatal("NakamotoCoefficient with overflowing stake succeeded")
+	}
+}
+
+func TestSelectValidatorMatchesStakeWeight(t *testing.T) {
+	// Small stakes make any off-by-one in the selection ranges a large bias
+	validators := []*Validator{
+		{Address: []byte("a"), Stake: 1},
+		{Address: []byte("b"), Stake: 2},
+		{Address: []byte("c"), Stake: 3},
+		{Address: []byte("d"), Stake: 4},
+	}
+	const runs = 100000
+	const tolerance = 0.005
+
+	counts := make(map[string]int)
+	block := &Block{PrevBlockHash: []byte("parent")}
+	pos := NewProofOfStake(block, validators...)
+	for i := 0; i < runs; i++ {
+		// Each height seeds a different selection
+		block.Height = i
+		v, total, err := pos.selectValidator()
+		if err != nil {
+			t.Fatalf("selectValidator: %v", err)
+		}
+		if total != 10 {
+			t.Fatalf("total stake = %d, want 10", total)
+		}
+		counts[string(v.Address)]++
+	}
+
+	for _, v := range validators {
+		got := float64(counts[string(v.Address)]) / runs
+		want := float64(v.Stake) / 10
+		if math.Abs(got-want) > tolerance {
+			t.Errorf("validator %s selected %.4f of the time, want %.4f ± %.3f", v.Address, got, want, tolerance)
+		}
+	}
+}
+
+func BenchmarkPoSSelect(b *testing.B) {
+	block := &Block{PrevBlockHash: []byte("parent")}
+	pos := NewProofOfStake(block, createMockValidators()...)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		block.Height = i
+		if _, _, err := pos.selectValidator(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestValidatorAddressFromPubKey(t *testing.T) {
+	w := newTestWallet(t)
+
+	first := ValidatorAddressFromPubKey(&w.PrivateKey.PublicKey)
+	if first == nil {
+		t.Fatal("ValidatorAddressFromPubKey returned nil for a valid key")
+	}
+	if again := ValidatorAddressFromPubKey(&w.PrivateKey.PublicKey); !bytes.Equal(again, first) {
+		t.Fatalf("same key gave %s, then %s", first, again)
+	}
+	if !bytes.Equal(first, w.Address()) {
+		t.Fatalf("validator address %s differs from wallet address %s", first, w.Address())
+	}
+
+	other := newTestWallet(t)
+	if bytes.Equal(ValidatorAddressFromPubKey(&other.PrivateKey.PublicKey), first) {
+		t.Fatal("different keys gave the same validator address")
+	}
+	if addr := ValidatorAddressFromPubKey(&ecdsa.PublicKey{}); addr != nil {
+		t.Fatalf("ValidatorAddressFromPubKey of an invalid key = %s, want nil", addr)
+	}
+}
+
+// selectionCounts runs selection for heights [0, n) of block and counts how
+// often each validator is chosen. It also returns the last total stake.
+func selectionCounts(t *testing.T, pos *ProofOfStake, block *Block, n int) (map[string]int, uint64) {
+	t.Helper()
+	counts := make(map[string]int)
+	var total uint64
+	for i := 0; i < n; i++ {
+		block.Height = i
+		v, stake, err := pos.selectValidator()
+		if err != nil {
+			t.Fatalf("selectValidator: %v", err)
+		}
+		counts[string(v.Address)]++
+		total = stake
+	}
+	return counts, total
+}
+
+func TestAddAndRemoveValidator(t *testing.T) {
+	block := &Block{PrevBlockHash: []byte("parent")}
+	pos := NewProofOfStake(block, createMockValidators()...)
+	if _, total := selectionCounts(t, pos, block, 1); total != 6000 {
+		t.Fatalf("total stake = %d, want 6000", total)
+	}
+
+	if err := pos.AddValidator(&Validator{Address: []byte("validator4"), Stake: 4000}); err != nil {
+		t.Fatalf("AddValidator: %v", err)
+	}
+	counts, total := selectionCounts(t, pos, block, 1000)
+	if total != 10000 {
+		t.Fatalf("total stake after adding = %d, want 10000", total)
+	}
+	if counts["validator4"] == 0 {
+		t.Fatal("added validator was never selected")
+	}
+
+	// Duplicates and validators without stake are rejected
+	if err := pos.AddValidator(&Validator{Address: []byte("validator1"), Stake: 10}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("AddValidator of a duplicate = %v, want already exists", err)
+	}
+	if err := pos.AddValidator(&Validator{Address: []byte("validator5")}); err == nil || !strings.Contains(err.Error(), "no stake") {
+		t.Errorf("AddValidator without stake = %v, want no stake", err)
+	}
+
+	if err := pos.RemoveValidator([]byte("validator3")); err != nil {
+		t.Fatalf("RemoveValidator: %v", err)
+	}
+	counts, total = selectionCounts(t, pos, block, 1000)
+	if total != 7000 {
+		t.Fatalf("total stake after removing = %d, want 7000", total)
+	}
+	if n := counts["validator3"]; n != 0 {
+		t.Fatalf("removed validator selected %d times", n)
+	}
+	if err := pos.RemoveValidator([]byte("validator3")); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("RemoveValidator of a missing validator = %v, want not found", err)
+	}
+}
+
+func TestMinStakeExcludesSmallValidators(t *testing.T) {
+	block := &Block{PrevBlockHash: []byte("parent")}
+	pos := NewProofOfStake(block, createMockValidators()...)
+	pos.SetMinStake(2000)
+
+	// validator1 stakes 1000, below the minimum
+	counts, total := selectionCounts(t, pos, block, 1000)
+	if total != 5000 {
+		t.Fatalf("total stake = %d, want 5000 without validator1", total)
+	}
+	if n := counts["validator1"]; n != 0 {
+		t.Fatalf("validator below the minimum selected %d times", n)
+	}
+	if n := pos.ValidatorCount(); n != 2 {
+		t.Errorf("ValidatorCount = %d, want 2", n)
+	}
+
+	// A block forged by the excluded validator doesn't validate
+	block.Height = 1
+	block.ValidatorID = []byte("validator1")
+	if valid, _ := pos.Validate(); valid {
+		t.Error("Validate accepted a block from a validator below the minimum")
+	}
+}
+
+func TestTwoValidatorsAlternate(t *testing.T) {
+	validators := createMockValidators()[:2]
+	var prev []byte
+	for height := 1; height <= 10; height++ {
+		block := &Block{PrevBlockHash: []byte("parent"), Height: height, Consensus: POS}
+		pos := NewProofOfStake(block, validators...)
+		pos.SetPreviousValidator(prev)
+		validatorID, hash, err := pos.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run at height %d: %v", height, err)
+		}
+		if bytes.Equal(validatorID, prev) {
+			t.Fatalf("%s forged heights %d and %d", validatorID, height-1, height)
+		}
+		block.ValidatorID, block.Hash = validatorID, hash
+		if valid, err := pos.Validate(); !valid || err != nil {
+			t.Fatalf("Validate at height %d = %v, %v", height, valid, err)
+		}
+		prev = validatorID
+	}
+}
+
+func TestPoSRejectsSameProducerTwice(t *testing.T) {
+	validators := createMockValidators()[:2]
+	block := &Block{PrevBlockHash: []byte("parent"), Height: 1, Consensus: POS}
+	validatorID, hash, err := NewProofOfStake(block, validators...).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	block.ValidatorID, block.Hash = validatorID, hash
+
+	// The block is properly signed, but its producer also forged the parent
+	pos := NewProofOfStake(block, validators...)
+	pos.SetPreviousValidator(validatorID)
+	valid, err := pos.Validate()
+	if valid || err == nil || !strings.Contains(err.Error(), "forged the previous block") {
+		t.Fatalf("Validate of a repeated producer = %v, %v, want rejection", valid, err)
+	}
+
+	// A lone validator may forge every block
+	lone := NewProofOfStake(block, validators[:1]...)
+	lone.SetPreviousValidator(validators[0].Address)
+	if _, _, err := lone.Run(context.Background()); err != nil {
+		t.Fatalf("Run with a lone validator: %v", err)
+	}
+}
+
+func TestPoSChainNeverRepeatsProducer(t *testing.T) {
+	bc := newTestChain(t, POS)
+	addTestBlocks(t, bc, 10)
+
+	blocks := bc.mustChain()
+	for i := 1; i < len(blocks); i++ {
+		if bytes.Equal(blocks[i].ValidatorID, blocks[i-1].ValidatorID) {
+			t.Errorf("%s forged heights %d and %d", blocks[i].ValidatorID, i-1, i)
+		}
+	}
+}
+
+func TestRunCreditsBlockReward(t *testing.T) {
+	validators := createMockValidators()
+	before := make(map[string]uint64)
+	for _, v := range validators {
+		before[string(v.Address)] = v.Balance
+	}
+
+	block := &Block{PrevBlockHash: []byte("parent"), Height: 1, Consensus: POS}
+	pos := NewProofOfStake(block, validators...)
+	pos.SetBlockReward(25)
+	validatorID, _, err := pos.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if block.ValidatorReward != 25 {
+		t.Errorf("block records reward %d, want 25", block.ValidatorReward)
+	}
+
+	// Only the producer's balance grows, by exactly the reward
+	for _, v := range validators {
+		want := before[string(v.Address)]
+		if bytes.Equal(v.Address, validatorID) {
+			want += 25
+		}
+		if v.Balance != want {
+			t.Errorf("validator %s balance = %d, want %d", v.Address, v.Balance, want)
+		}
+	}
+}
+
+func TestPoSSignatures(t *testing.T) {
+	validators := createMockValidators()
+	block := &Block{PrevBlockHash: []byte("parent"), Height: 1, Consensus: POS}
+	validatorID, hash, err := NewProofOfStake(block, validators...).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	block.ValidatorID, block.Hash = validatorID, hash
+	signature := block.Signature
+
+	if valid, err := NewProofOfStake(block, validators...).Validate(); !valid || err != nil {
+		t.Fatalf("Validate of a correctly signed block = %v, %v", valid, err)
+	}
+
+	// Signed by another validator's key
+	var forger *Validator
+	for _, v := range validators {
+		if !bytes.Equal(v.Address, validatorID) {
+			forger = v
+		}
+	}
+	forged := ed25519.Sign(forger.SigningKey, block.hashData(validatorID))
+	tampered := bytes.Clone(signature)
+	tampered[0] ^= 0xff
+
+	tests := []struct {
+		name      string
+		signature []byte
+		wantErr   string
+	}{
+		{"forged", forged, "invalid signature"},
+		{"tampered", tampered, "invalid signature"},
+		{"missing", nil, "not signed"},
+	}
+	for _, tt := range tests {
+		block.Signature = tt.signature
+		valid, err := NewProofOfStake(block, validators...).Validate()
+		if valid || err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate of a %s signature = %v, %v, want %q", tt.name, valid, err, tt.wantErr)
+		}
+	}
+
+	// Without the validator's public key nothing can be verified
+	block.Signature = signature
+	for _, v := range validators {
+		v.PublicKey = nil
+	}
+	if valid, err := NewProofOfStake(block, validators...).Validate(); valid || err == nil || !strings.Contains(err.Error(), "no public key") {
+		t.Errorf("Validate without a public key = %v, %v, want no public key error", valid, err)
+	}
+}
+
+func TestBlockRecordsSelectionStake(t *testing.T) {
+	bc := newTestChain(t, POS)
+	addTestBlocks(t, bc, 3)
+
+	stakes := make(map[string]uint64)
+	for _, v := range createMockValidators() {
+		stakes[string(v.Address)] = v.Stake
+	}
+	blocks := bc.mustChain()
+	for _, block := range blocks[1:] {
+		// The previous producer is excluded from selection
+		want := uint64(6000) - stakes[string(blocks[block.Height-1].ValidatorID)]
+		if block.TotalStake != want {
+			t.Errorf("block %d records total stake %d, want %d", block.Height, block.TotalStake, want)
+		}
+		if block.ValidatorStake != stakes[string(block.ValidatorID)] {
+			t.Errorf("block %d records stake %d for %s, want %d", block.Height, block.ValidatorStake, block.ValidatorID, stakes[string(block.ValidatorID)])
+		}
+		if p := block.SelectionProbability(); p <= 0 || p > 1 {
+			t.Errorf("block %d selection probability = %f", block.Height, p)
+		}
+	}
+
+	// Both fields are covered by the hash, and Validate checks them
+	tip := *blocks[len(blocks)-1]
+	for name, mutate := range map[string]func(b *Block){
+		"validator stake": func(b *Block) { b.ValidatorStake++ },
+		"total stake":     func(b *Block) { b.TotalStake++ },
+	} {
+		block := tip
+		mutate(&block)
+		if bytes.Equal(block.ComputeHash(), tip.Hash) {
+			t.Errorf("changing the %s left the hash unchanged", name)
+		}
+		pos := NewProofOfStake(&block, createMockValidators()...)
+		pos.SetPreviousValidator(blocks[len(blocks)-2].ValidatorID)
+		if valid, err := pos.Validate(); valid || err == nil || !strings.Contains(err.Error(), "block records stake") {
+			t.Errorf("Validate with a changed %s = %v, %v, want stake error", name, valid, err)
+		}
+	}
+}
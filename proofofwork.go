@@ -3,66 +3,212 @@ package main
 
 import (
 	"bytes"           // for comparing and combining byte slices
+	"context"         // for cancelling mining
 	"crypto/sha256"   // for hashing
 	"encoding/binary" // for converting to binary
+	"errors"          // for sentinel errors
 	"fmt"             // for printing
 	"math"            // for math operations
 	"math/big"        // for working with large integers
+	"runtime"         // for counting CPUs
+	"time"            // for the mining watchdog
 )
 
-// Difficulty of mining. In Bitcoin this is adjusted dynamically.
-// Here we'll keep it constant for simplicity
-const targetBits = 16
+// Default difficulty of mining. In Bitcoin this is adjusted dynamically.
+// Each block records the difficulty it was mined at in TargetBits.
+const defaultTargetBits = 16
+
+// checkInterval is how many nonces a worker tries between cancellation checks
+const checkInterval = 4096
+
+// maxTargetBits is the highest difficulty a SHA-256 hash can meet
+const maxTargetBits = 256
+
+// errNonceRangeExhausted is returned by a worker that found no valid nonce
+var errNonceRangeExhausted = errors.New("nonce range exhausted")
 
 // ProofOfWork represents a proof-of-work system
 type ProofOfWork struct {
-	block  *Block   // pointer to the block for which we're calculating proof-of-work
-	target *big.Int // target threshold below which hash must be
+	block      *Block              // pointer to the block for which we're calculating proof-of-work
+	targetBits int                 // difficulty, taken from the block
+	target     *big.Int            // target threshold below which hash must be
+	header     []byte              // hashed block fields preceding the nonce
+	maxNonce   int64               // highest nonce tried before giving up
+	workers    int                 // number of goroutines mining in parallel
+	watchdog   time.Duration       // how long mining may run before onStuck fires (0 = disabled)
+	onStuck    func(time.Duration) // called once when mining exceeds watchdog
+	logger     Logger              // receives mining status messages
+
+	// OnProgress, if set, is called with every nonce tried and its hash.
+	// Mining workers call it concurrently, each with increasing nonces.
+	OnProgress func(nonce int64, hash []byte)
 }
 
-// NewProofOfWork builds and returns a ProofOfWork
+// NewProofOfWork builds and returns a ProofOfWork for the block's TargetBits.
+// The block's fields are captured up front, so it must not change afterwards.
 func NewProofOfWork(b *Block) *ProofOfWork {
-	// Initialize a big integer as 1
-	target := big.NewInt(1)
-	// Left shift it by (256 - targetBits)
-	// This sets our target threshold: any hash below this is valid
-	target.Lsh(target, uint(256-targetBits))
+	pow := &ProofOfWork{
+		block:      b,
+		targetBits: b.TargetBits,
+		target:     big.NewInt(0),
+		header:     b.hashData(nil),
+		maxNonce:   math.MaxInt64,
+		workers:    runtime.NumCPU(),
+		logger:     nopLogger{},
+	}
+
+	// An out-of-range difficulty leaves the target at zero, which no hash
+	// can beat, rather than shifting by a nonsensical amount. Run and
+	// Validate reject it up front.
+	if pow.checkTargetBits() == nil {
+		// Initialize a big integer as 1
+		pow.target.SetInt64(1)
+		// Left shift it by (256 - targetBits)
+		// This sets our target threshold: any hash below this is valid
+		pow.target.Lsh(pow.target, uint(256-pow.targetBits))
+	}
 
-	pow := &ProofOfWork{b, target}
 	return pow
 }
 
-// prepareData combines block fields with nonce and targetBits for hashing
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.Data,
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
+// SetWatchdog registers a callback fired once if mining runs longer than d,
+// which usually means the difficulty is misconfigured
+func (pow *ProofOfWork) SetWatchdog(d time.Duration, onStuck func(elapsed time.Duration)) {
+	pow.watchdog = d
+	pow.onStuck = onStuck
+}
+
+// SetLogger routes mining status messages to logger
+func (pow *ProofOfWork) SetLogger(logger Logger) {
+	pow.logger = logger
+}
+
+// SetMaxNonce limits mining to nonces in [0, max], after which Run gives up
+// with an error. The default is math.MaxInt64.
+func (pow *ProofOfWork) SetMaxNonce(max int64) {
+	pow.maxNonce = max
+}
+
+// checkTargetBits rejects a difficulty no hash can be compared against
+func (pow *ProofOfWork) checkTargetBits() error {
+	if pow.targetBits < 0 || pow.targetBits > maxTargetBits {
+		return fmt.Errorf("target bits %d out of range [0, %d]", pow.targetBits, maxTargetBits)
+	}
+	return nil
+}
+
+// prepareData combines block fields with nonce for hashing
+func (pow *ProofOfWork) prepareData(nonce int64) []byte {
+	data := pow.newData()
+	pow.setNonce(data, nonce)
+	return data
+}
+
+// newData returns a buffer holding the cached header followed by room for
+// the nonce, so mining loops can reuse it instead of allocating per nonce
+func (pow *ProofOfWork) newData() []byte {
+	data := make([]byte, len(pow.header)+8)
+	copy(data, pow.header)
 	return data
 }
 
-// Run performs the proof-of-work computation
+// setNonce writes nonce into a buffer from newData, matching IntToHex
+func (pow *ProofOfWork) setNonce(data []byte, nonce int64) {
+	binary.BigEndian.PutUint64(data[len(pow.header):], uint64(nonce))
+}
+
+// Run performs the proof-of-work computation until a valid nonce is found
+// or ctx is cancelled. The nonce space is split into disjoint ranges mined
+// in parallel, and the first worker to succeed stops the others.
 // Returns miner ID (nonce as bytes) and resulting hash
-func (pow *ProofOfWork) Run() ([]byte, []byte) {
-	var hashInt big.Int // holds the integer representation of our hash
-	var hash [32]byte   // holds the actual hash bytes
-	nonce := 0          // counter we'll increment while mining
+func (pow *ProofOfWork) Run(ctx context.Context) ([]byte, []byte, error) {
+	if err := pow.checkTargetBits(); err != nil {
+		return nil, nil, err
+	}
+	if pow.maxNonce < 0 {
+		return nil, nil, fmt.Errorf("max nonce %d is negative", pow.maxNonce)
+	}
+
+	pow.logger.Printf("Mining a new block...")
+
+	// Report if mining is taking suspiciously long
+	if pow.watchdog > 0 && pow.onStuck != nil {
+		start := time.Now()
+		timer := time.AfterFunc(pow.watchdog, func() { pow.onStuck(time.Since(start)) })
+		defer timer.Stop()
+	}
+
+	workers := pow.workers
+	if int64(workers) > pow.maxNonce {
+		workers = int(pow.maxNonce) + 1
+	}
+
+	mineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	fmt.Printf("Mining a new block...")
+	// Buffered so workers still running after we return never block
+	results := make(chan mineResult, workers)
+	span := pow.maxNonce / int64(workers)
+	for w := 0; w < workers; w++ {
+		from := int64(w) * span
+		to := from + span - 1
+		if w == workers-1 {
+			to = pow.maxNonce
+		}
+
+		go func() {
+			nonce, hash, err := pow.mineRange(mineCtx, from, to)
+			results <- mineResult{nonce, hash, err}
+		}()
+	}
+
+	for w := 0; w < workers; w++ {
+		result := <-results
+		if result.err == nil {
+			pow.logger.Printf("Block mined! Nonce: %d", result.nonce)
+			// Convert nonce to bytes to match Consensus interface
+			return IntToHex(result.nonce), result.hash, nil
+		}
+	}
+
+	// Every worker failed, either because ctx was cancelled or because
+	// the whole nonce space was exhausted
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, fmt.Errorf("no valid nonce found in [0, %d]", pow.maxNonce)
+}
+
+// mineResult is the outcome of mining one nonce range
+type mineResult struct {
+	nonce int64  // nonce that produced a valid hash
+	hash  []byte // the valid hash
+	err   error  // set if no valid nonce was found
+}
+
+// mineRange searches nonces in [from, to] for a hash below the target
+func (pow *ProofOfWork) mineRange(ctx context.Context, from, to int64) (int64, []byte, error) {
+	var hashInt big.Int   // holds the integer representation of our hash
+	var hash [32]byte     // holds the actual hash bytes
+	data := pow.newData() // reused for every nonce
+
+	for nonce := from; ; nonce++ {
+		// Periodically stop if the caller gave up or another worker won
+		if (nonce-from)%checkInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return 0, nil, ctx.Err()
+			default:
+			}
+		}
 
-	// Essentially infinite loop until we find a valid hash
-	for nonce < math.MaxInt64 {
 		// Prepare data for hashing
-		data := pow.prepareData(nonce)
+		pow.setNonce(data, nonce)
 		// Calculate hash of the data
 		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash) // Show mining progress
+		if pow.OnProgress != nil {
+			pow.OnProgress(nonce, hash[:])
+		}
 
 		// Convert hash to big integer
 		hashInt.SetBytes(hash[:])
@@ -70,30 +216,58 @@ func (pow *ProofOfWork) Run() ([]byte, []byte) {
 		// Compare with target
 		// If hash is less than target, we found a valid proof-of-work
 		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\nBlock mined! Nonce: %d\n", nonce)
+			return nonce, hash[:], nil
+		}
+
+		// Stop before incrementing past the range, which could overflow
+		if nonce >= to {
+			return 0, nil, errNonceRangeExhausted
+		}
+	}
+}
+
+// EstimateHashrate hashes the block with successive nonces for about d on a
+// single goroutine, ignoring the target, and returns the hashes per second.
+// The block is left unchanged.
+func (pow *ProofOfWork) EstimateHashrate(d time.Duration) float64 {
+	start := time.Now()
+	deadline := start.Add(d)
+
+	var hashes int64
+	data := pow.newData()
+	for nonce := int64(0); ; nonce++ {
+		// Reading the clock is slow, so only check it periodically
+		if nonce%checkInterval == 0 && nonce > 0 && !time.Now().Before(deadline) {
 			break
-		} else {
-			nonce++
 		}
+		pow.setNonce(data, nonce)
+		sha256.Sum256(data)
+		hashes++
 	}
 
-	// Convert nonce to bytes to match Consensus interface
-	return IntToHex(int64(nonce)), hash[:]
+	return float64(hashes) / time.Since(start).Seconds()
 }
 
 // Validate verifies the proof-of-work
-func (pow *ProofOfWork) Validate() bool {
+func (pow *ProofOfWork) Validate() (bool, error) {
+	if err := pow.checkTargetBits(); err != nil {
+		return false, err
+	}
+
 	var hashInt big.Int
 
 	// Convert ValidatorID (which contains the nonce) back to int
-	nonce := int(binary.BigEndian.Uint64(pow.block.ValidatorID))
+	nonce, err := IntFromHex(pow.block.ValidatorID)
+	if err != nil {
+		return false, fmt.Errorf("decode nonce: %w", err)
+	}
 
 	data := pow.prepareData(nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
 	isValid := hashInt.Cmp(pow.target) == -1
-	return isValid
+	return isValid, nil
 }
 
 // IntToHex converts an int64 to a byte array
@@ -105,3 +279,11 @@ func IntToHex(num int64) []byte {
 	}
 	return buff.Bytes()
 }
+
+// IntFromHex converts a byte array produced by IntToHex back to an int64
+func IntFromHex(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("want 8 bytes, got %d", len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"         // for comparing nonces and hashes
+	"context"       // for running the miner
+	"crypto/sha256" // for hashing prepared data
+	"errors"        // for matching cancellation errors
+	"math"          // for extreme nonces
+	"math/big"      // for comparing hashes with targets
+	"runtime"       // for counting CPUs
+	"strings"       // for matching error messages
+	"testing"       // for the test harness
+	"time"          // for bounding test runs
+)
+
+func TestPoWRejectsOutOfRangeTargetBits(t *testing.T) {
+	for _, bits := range []int{-1, maxTargetBits + 1, 300} {
+		pow := NewProofOfWork(&Block{TargetBits: bits, ValidatorID: IntToHex(0)})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, _, err := pow.Run(ctx)
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("Run with %d target bits = %v, want range error", bits, err)
+		}
+		if valid, err := pow.Validate(); valid || err == nil {
+			t.Errorf("Validate with %d target bits = %v, %v, want range error", bits, valid, err)
+		}
+	}
+}
+
+func TestPoWRunStopsAtMaxNonce(t *testing.T) {
+	// No hash of a few thousand nonces meets 200 bits, so Run must give up
+	pow := NewProofOfWork(&Block{TargetBits: 200})
+	pow.SetMaxNonce(5000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, err := pow.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "no valid nonce found in [0, 5000]") {
+		t.Fatalf("Run = %v, want exhausted nonce error", err)
+	}
+}
+
+func TestPoWRunFindsValidNonce(t *testing.T) {
+	block := &Block{TargetBits: testTargetBits}
+	pow := NewProofOfWork(block)
+
+	nonce, hash, err := pow.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	block.ValidatorID, block.Hash = nonce, hash
+	if valid, err := NewProofOfWork(block).Validate(); !valid || err != nil {
+		t.Fatalf("Validate of a mined block = %v, %v", valid, err)
+	}
+}
+
+func TestPoWOnProgressSeesIncreasingNonces(t *testing.T) {
+	pow := NewProofOfWork(&Block{TargetBits: testTargetBits})
+	// A single worker tries nonces in order, so every call can be checked
+	pow.workers = 1
+	var nonces []int64
+	var lastHash []byte
+	pow.OnProgress = func(nonce int64, hash []byte) {
+		nonces = append(nonces, nonce)
+		lastHash = append(lastHash[:0], hash...)
+	}
+
+	nonce, hash, err := pow.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(nonces) == 0 {
+		t.Fatal("OnProgress was never called")
+	}
+	for i, n := range nonces {
+		if n != int64(i) {
+			t.Fatalf("OnProgress call %d got nonce %d, want %d", i, n, i)
+		}
+	}
+	// The last nonce reported is the winning one
+	if got := IntToHex(nonces[len(nonces)-1]); !bytes.Equal(got, nonce) {
+		t.Errorf("last reported nonce %x, Run returned %x", got, nonce)
+	}
+	if !bytes.Equal(lastHash, hash) {
+		t.Errorf("last reported hash %x, Run returned %x", lastHash, hash)
+	}
+}
+
+func TestChainMinesAtConfiguredDifficulty(t *testing.T) {
+	for _, bits := range []int{8, 12} {
+		bc, err := NewBlockchainWithStore(NewMemoryStore(), POW, bits)
+		if err != nil {
+			t.Fatalf("NewBlockchainWithStore at %d bits: %v", bits, err)
+		}
+		addTestBlocks(t, bc, 1)
+
+		genesis := bc.mustChain()[0]
+		if genesis.TargetBits != bits {
+			t.Errorf("genesis mined at %d bits, want %d", genesis.TargetBits, bits)
+		}
+		// The hash must have at least bits leading zero bits
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(256-bits))
+		if new(big.Int).SetBytes(genesis.Hash).Cmp(limit) >= 0 {
+			t.Errorf("genesis hash %x does not meet %d bits", genesis.Hash, bits)
+		}
+		if err := bc.Validate(); err != nil {
+			t.Errorf("Validate at %d bits: %v", bits, err)
+		}
+	}
+}
+
+func TestPoWRunStopsWhenCancelled(t *testing.T) {
+	// No nonce meets 200 bits, so only cancellation ends mining
+	pow := NewProofOfWork(&Block{TargetBits: 200})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(50*time.Millisecond, cancel)
+	defer timer.Stop()
+
+	start := time.Now()
+	_, _, err := pow.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Run took %v to notice cancellation", elapsed)
+	}
+}
+
+func TestNewBlockPropagatesTimeout(t *testing.T) {
+	// No nonce meets 200 bits, so only the deadline ends mining
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	block, err := NewBlock(ctx, []*Transaction{dataTx("slow")}, []byte("parent"), 1, POW, 200)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("NewBlock = %v, want context.DeadlineExceeded", err)
+	}
+	if block != nil {
+		t.Errorf("NewBlock returned block %x alongside the error", block.Hash)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("NewBlock took %v to notice the deadline", elapsed)
+	}
+
+	// PoS selection is instant but still honours an expired context
+	if _, err := NewBlock(ctx, []*Transaction{dataTx("late")}, []byte("parent"), 1, POS, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("NewBlock with PoS = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestPoWWatchdogFires(t *testing.T) {
+	// No nonce meets 200 bits, so mining only ends when cancelled
+	pow := NewProofOfWork(&Block{TargetBits: 200})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stuck := make(chan time.Duration, 1)
+	pow.SetWatchdog(20*time.Millisecond, func(elapsed time.Duration) {
+		stuck <- elapsed
+		cancel()
+	})
+
+	if _, _, err := pow.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled from the watchdog", err)
+	}
+	if elapsed := <-stuck; elapsed < 20*time.Millisecond {
+		t.Fatalf("watchdog reported %v, want at least 20ms", elapsed)
+	}
+}
+
+func TestMiningWatchdogReachesAddBlock(t *testing.T) {
+	bc := newTestChain(t, POW)
+	// A floor no nonce can meet makes the next block impossible to mine
+	bc.SetMinTargetBits(200)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var elapsed time.Duration
+	bc.SetMiningWatchdog(20*time.Millisecond, func(d time.Duration) {
+		elapsed = d
+		cancel()
+	})
+
+	if err := bc.AddBlockContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AddBlockContext = %v, want context.Canceled from the watchdog", err)
+	}
+	if elapsed < 20*time.Millisecond {
+		t.Fatalf("watchdog reported %v, want at least 20ms", elapsed)
+	}
+}
+
+func TestEstimateHashrate(t *testing.T) {
+	block := &Block{Height: 1, TargetBits: testTargetBits, Transactions: []*Transaction{dataTx("rate")}}
+	before, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pow := NewProofOfWork(block)
+
+	first := pow.EstimateHashrate(100 * time.Millisecond)
+	second := pow.EstimateHashrate(100 * time.Millisecond)
+	if first <= 0 || second <= 0 {
+		t.Fatalf("EstimateHashrate = %.0f and %.0f, want positive rates", first, second)
+	}
+	// Loose bounds, as other tests and the race detector share the CPU
+	if ratio := first / second; ratio < 0.25 || ratio > 4 {
+		t.Errorf("EstimateHashrate gave %.0f then %.0f H/s, want roughly the same", first, second)
+	}
+
+	after, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Error("EstimateHashrate changed the block")
+	}
+}
+
+func TestIntFromHex(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 255, 1 << 40, math.MaxInt64, math.MinInt64} {
+		got, err := IntFromHex(IntToHex(n))
+		if err != nil || got != n {
+			t.Errorf("IntFromHex(IntToHex(%d)) = %d, %v", n, got, err)
+		}
+	}
+
+	for _, b := range [][]byte{nil, {}, {1, 2, 3}, make([]byte, 7), make([]byte, 9)} {
+		if _, err := IntFromHex(b); err == nil || !strings.Contains(err.Error(), "want 8 bytes") {
+			t.Errorf("IntFromHex(%x) = %v, want length error", b, err)
+		}
+	}
+
+	// PoW validation reports a malformed nonce instead of panicking
+	block := &Block{TargetBits: testTargetBits, ValidatorID: []byte{1, 2}}
+	if valid, err := NewProofOfWork(block).Validate(); valid || err == nil {
+		t.Errorf("Validate with a short nonce = %v, %v, want error", valid, err)
+	}
+}
+
+// benchmarkTargetBits is the difficulty the mining benchmarks compare
+// single-threaded and parallel mining at
+const benchmarkTargetBits = 20
+
+// benchmarkMining mines a block at benchmarkTargetBits with the given
+// number of workers
+func benchmarkMining(b *testing.B, workers int) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pow := NewProofOfWork(&Block{Height: i, TargetBits: benchmarkTargetBits})
+		pow.workers = workers
+		if _, _, err := pow.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPoWRunSingle(b *testing.B) {
+	benchmarkMining(b, 1)
+}
+
+func BenchmarkPoWRunParallel(b *testing.B) {
+	benchmarkMining(b, runtime.NumCPU())
+}
+
+// BenchmarkPoWHash measures one mining attempt, which reuses the cached
+// header and so shouldn't allocate
+func BenchmarkPoWHash(b *testing.B) {
+	// No hash meets the full 256 bits, so every nonce is tried
+	pow := NewProofOfWork(&Block{TargetBits: maxTargetBits})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	if _, _, err := pow.mineRange(context.Background(), 0, int64(b.N)-1); err != errNonceRangeExhausted {
+		b.Fatalf("mineRange = %v, want %v", err, errNonceRangeExhausted)
+	}
+}
+
+// BenchmarkPoWPrepareData measures building the hashed data afresh for a
+// nonce, as validation does
+func BenchmarkPoWPrepareData(b *testing.B) {
+	pow := NewProofOfWork(&Block{TargetBits: testTargetBits})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sha256.Sum256(pow.prepareData(int64(i)))
+	}
+}
+
+func BenchmarkPoWRun(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pow := NewProofOfWork(&Block{Height: i, TargetBits: testTargetBits})
+		if _, _, err := pow.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPoWValidate(b *testing.B) {
+	block := &Block{TargetBits: testTargetBits}
+	nonce, hash, err := NewProofOfWork(block).Run(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	block.ValidatorID, block.Hash = nonce, hash
+	pow := NewProofOfWork(block)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if valid, err := pow.Validate(); !valid || err != nil {
+			b.Fatalf("Validate = %v, %v", valid, err)
+		}
+	}
+}
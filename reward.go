@@ -0,0 +1,134 @@
+// Package main implements the block reward schedule
+package main
+
+import (
+	"bytes"  // for matching validator addresses
+	"errors" // for error values
+	"fmt"    // for coinbase data
+)
+
+const (
+	defaultInitialReward   = 50     // reward for blocks before the first halving
+	defaultHalvingInterval = 210000 // blocks between reward halvings
+)
+
+// SetRewardSchedule configures block rewards to start at initial and halve
+// every interval blocks
+func (bc *Blockchain) SetRewardSchedule(initial uint64, interval int) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.initialReward = initial
+	bc.halvingInterval = interval
+}
+
+// RewardAt returns the reward for producing the block at height. It halves
+// every halvingInterval blocks and eventually reaches zero.
+func (bc *Blockchain) RewardAt(height int) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.rewardAt(height)
+}
+
+// rewardAt is RewardAt for callers holding bc.mu
+func (bc *Blockchain) rewardAt(height int) uint64 {
+	if height < 0 || bc.halvingInterval <= 0 {
+		return bc.initialReward
+	}
+
+	// Shifting a uint64 by 64 or more always gives zero
+	halvings := height / bc.halvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return bc.initialReward >> uint(halvings)
+}
+
+// SetRewardAddress makes AddBlock start each block with a coinbase
+// transaction paying the block reward and fees to address
+func (bc *Blockchain) SetRewardAddress(address []byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.rewardAddress = address
+}
+
+// SetRequireCoinbase makes validation require every block after genesis to
+// start with exactly one coinbase. Whether or not it is required, a block
+// may hold at most one coinbase, first, paying no more than the reward for
+// its height plus the block's fees.
+//
+// It is off by default because chains that only record data, such as PoA
+// chains and those built with the addblock command, have nobody to pay.
+// Chains paying rewards should turn it on alongside SetRewardAddress.
+func (bc *Blockchain) SetRequireCoinbase(require bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.requireCoinbase = require
+}
+
+// coinbaseFor returns the coinbase for a new block at height, claiming the
+// reward and the fees of the block's other transactions, or nil if no
+// reward address is set
+func (bc *Blockchain) coinbaseFor(height, fees int) *Transaction {
+	if bc.rewardAddress == nil {
+		return nil
+	}
+	// The height keeps coinbases paying the same address unique
+	return NewCoinbaseTX(bc.rewardAddress, fmt.Sprintf("coinbase at height %d", height), int(bc.rewardAt(height))+fees)
+}
+
+// checkCoinbase verifies that the block starts with a coinbase, if they are
+// required. checkTransactions limits what the coinbase pays and makes sure
+// it is the only one.
+func (bc *Blockchain) checkCoinbase(block *Block) error {
+	if !bc.requireCoinbase || block.Height == 0 {
+		return nil
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction is not a coinbase")
+	}
+	return nil
+}
+
+// SetValidatorReward sets the amount credited to the validator forging each
+// new PoS block after genesis, which the block records in ValidatorReward. Blocks recording
+// any other amount are rejected.
+func (bc *Blockchain) SetValidatorReward(reward uint64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.validatorReward = reward
+}
+
+// checkValidatorReward verifies that a PoS block after genesis credits its
+// validator the configured reward, and that other blocks credit nothing
+func (bc *Blockchain) checkValidatorReward(block *Block) error {
+	want := bc.validatorReward
+	if block.Consensus != POS || block.Height == 0 {
+		want = 0
+	}
+	if block.ValidatorReward != want {
+		return fmt.Errorf("validator reward %d, want %d", block.ValidatorReward, want)
+	}
+	return nil
+}
+
+// ValidatorRewards returns the total reward credited to the validator at
+// address by the PoS blocks it forged on the chain
+func (bc *Blockchain) ValidatorRewards(address []byte) (uint64, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.chain()
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, block := range blocks {
+		if block.Consensus == POS && bytes.Equal(block.ValidatorID, address) {
+			total += block.ValidatorReward
+		}
+	}
+	return total, nil
+}
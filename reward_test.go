@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings" // for matching error messages
+	"testing" // for the test harness
+)
+
+func TestAddBlockRequiresCoinbase(t *testing.T) {
+	bc := newTestChain(t, POW)
+	bc.SetRequireCoinbase(true)
+
+	err := bc.AddBlock(nil)
+	if err == nil || !strings.Contains(err.Error(), "not a coinbase") {
+		t.Fatalf("AddBlock without a coinbase = %v, want coinbase error", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate after the rejected block: %v", err)
+	}
+
+	bc.SetRewardAddress(newTestWallet(t).Address())
+	if err := bc.AddBlock(nil); err != nil {
+		t.Fatalf("AddBlock with a coinbase: %v", err)
+	}
+}
+
+func TestCoinbaseClaimsFees(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	bc.SetRequireCoinbase(true)
+	miner := newTestWallet(t)
+	bc.SetRewardAddress(miner.Address())
+
+	// Pay 40 of the 50, leaving a fee of 10
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 40, Address: w.Address()})
+	if err := bc.AddBlock([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got, want := bc.UTXOSet().Balance(miner.Address()), defaultInitialReward+10; got != want {
+		t.Fatalf("miner balance = %d, want %d", got, want)
+	}
+}
+
+func TestCoinbaseOverRewardIsRejected(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 40, Address: w.Address()})
+
+	tip := tipBlock(t, bc)
+	reward := int(bc.RewardAt(tip.Height+1)) + 10
+	valid := NewCoinbaseTX(w.Address(), "valid", reward)
+	greedy := NewCoinbaseTX(w.Address(), "greedy", reward+1)
+
+	err := bc.AcceptBlock(mineTestBlock(t, bc, nextTestDifficulty(t, bc), greedy, tx))
+	if err == nil || !strings.Contains(err.Error(), "more than the reward and fees") {
+		t.Fatalf("AcceptBlock with an over-reward coinbase = %v, want reward error", err)
+	}
+	if err := bc.AcceptBlock(mineTestBlock(t, bc, nextTestDifficulty(t, bc), valid, tx)); err != nil {
+		t.Fatalf("AcceptBlock with the reward and fees: %v", err)
+	}
+}
+
+func TestDataTransactionsAreNotCoinbases(t *testing.T) {
+	bc := newTestChain(t, POW)
+	bc.SetRequireCoinbase(true)
+
+	// A block holding only data still needs a coinbase
+	err := bc.AcceptBlock(mineTestBlock(t, bc, nextTestDifficulty(t, bc), dataTx("note")))
+	if err == nil || !strings.Contains(err.Error(), "not a coinbase") {
+		t.Fatalf("AcceptBlock of a data-only block = %v, want coinbase error", err)
+	}
+
+	// With a reward address, data transactions follow the coinbase
+	bc.SetRewardAddress(newTestWallet(t).Address())
+	if err := bc.AddBlock([]*Transaction{dataTx("first"), dataTx("second")}); err != nil {
+		t.Fatalf("AddBlock of data transactions: %v", err)
+	}
+	txs := tipBlock(t, bc).Transactions
+	if len(txs) != 3 || !txs[0].IsCoinbase() || txs[1].IsCoinbase() || txs[2].IsCoinbase() {
+		t.Fatalf("block holds %d transactions, want a coinbase and two data transactions", len(txs))
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestSecondCoinbaseIsRejected(t *testing.T) {
+	bc, w, _ := fundedTestChain(t)
+	first := NewCoinbaseTX(w.Address(), "first", 1)
+	second := NewCoinbaseTX(w.Address(), "second", 1)
+
+	err := bc.AcceptBlock(mineTestBlock(t, bc, nextTestDifficulty(t, bc), first, second))
+	if err == nil || !strings.Contains(err.Error(), "is not the coinbase") {
+		t.Fatalf("AcceptBlock with two coinbases = %v, want coinbase error", err)
+	}
+	if err := bc.AddBlock([]*Transaction{second}); err == nil || !strings.Contains(err.Error(), "is not the coinbase") {
+		t.Fatalf("AddBlock with a coinbase while paying rewards = %v, want coinbase error", err)
+	}
+}
+
+func TestValidatorRewardsAreRecordedOnChain(t *testing.T) {
+	bc := newTestChain(t, POS)
+	bc.SetValidatorReward(10)
+	addTestBlocks(t, bc, 6)
+
+	var total uint64
+	for _, v := range createMockValidators() {
+		rewards, err := bc.ValidatorRewards(v.Address)
+		if err != nil {
+			t.Fatalf("ValidatorRewards: %v", err)
+		}
+		total += rewards
+	}
+	if total != 60 {
+		t.Fatalf("validators were credited %d in total, want 60 for 6 blocks", total)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	// Blocks crediting a different amount are rejected
+	bc.SetValidatorReward(20)
+	if err := bc.Validate(); err == nil || !strings.Contains(err.Error(), "validator reward 10, want 20") {
+		t.Fatalf("Validate with a different reward = %v, want reward error", err)
+	}
+}
+
+func TestRewardHalving(t *testing.T) {
+	bc := newTestChain(t, POW)
+	if got := bc.RewardAt(0); got != defaultInitialReward {
+		t.Fatalf("default genesis reward = %d, want %d", got, defaultInitialReward)
+	}
+
+	bc.SetRewardSchedule(100, 10)
+	tests := []struct {
+		height int
+		want   uint64
+	}{
+		{0, 100},
+		{9, 100},
+		{10, 50},
+		{19, 50},
+		{20, 25},
+		{30, 12}, // halving floors odd amounts
+		{60, 1},
+		{69, 1},
+		{70, 0}, // reaches zero
+		{640, 0},
+		{1 << 30, 0}, // far more halvings than bits in the reward
+	}
+	for _, tt := range tests {
+		if got := bc.RewardAt(tt.height); got != tt.want {
+			t.Errorf("RewardAt(%d) = %d, want %d", tt.height, got, tt.want)
+		}
+	}
+
+	// Mined coinbases follow the schedule
+	bc.SetRewardSchedule(8, 2)
+	bc.SetRewardAddress(newTestWallet(t).Address())
+	addTestBlocks(t, bc, 4)
+	for i, want := range []int{8, 4, 4, 2} {
+		block := bc.mustChain()[i+1]
+		if got := block.Transactions[0].Vout[0].Value; got != want {
+			t.Errorf("coinbase at height %d pays %d, want %d", block.Height, got, want)
+		}
+	}
+}
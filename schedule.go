@@ -0,0 +1,80 @@
+// Package main implements the consensus schedule of a chain
+package main
+
+import "fmt" // for error messages
+
+// scheduleKey is the store metadata key holding the consensus schedule
+const scheduleKey = "consensus-schedule"
+
+// consensusSwitch records that blocks from Height on are produced with Type
+type consensusSwitch struct {
+	Height int           // first block produced with Type
+	Type   ConsensusType // consensus mechanism in force from Height
+}
+
+// loadSchedule reads the consensus schedule from the store. Chains saved
+// without one use the consensus of their genesis block throughout.
+func (bc *Blockchain) loadSchedule(genesis *Block) error {
+	data, err := bc.store.GetMeta(scheduleKey)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		bc.schedule = []consensusSwitch{{Height: 0, Type: genesis.Consensus}}
+		return nil
+	}
+
+	var schedule []consensusSwitch
+	if err := gobDecode(data, &schedule); err != nil {
+		return fmt.Errorf("decode consensus schedule: %w", err)
+	}
+	if len(schedule) == 0 || schedule[0].Height != 0 || schedule[0].Type != genesis.Consensus {
+		return fmt.Errorf("consensus schedule does not start from the genesis consensus %s", genesis.Consensus)
+	}
+	bc.schedule = schedule
+	return nil
+}
+
+// scheduleSwitch makes t the consensus for blocks from height on and saves
+// the schedule. Switching again before a block is produced replaces the
+// earlier switch.
+func (bc *Blockchain) scheduleSwitch(height int, t ConsensusType) error {
+	schedule := append([]consensusSwitch(nil), bc.schedule...)
+	if last := &schedule[len(schedule)-1]; len(schedule) > 1 && last.Height == height {
+		last.Type = t
+	} else {
+		schedule = append(schedule, consensusSwitch{Height: height, Type: t})
+	}
+
+	data, err := gobEncode(schedule)
+	if err != nil {
+		return err
+	}
+	if err := bc.store.PutMeta(scheduleKey, data); err != nil {
+		return err
+	}
+	bc.schedule = schedule
+	return nil
+}
+
+// consensusAt returns the consensus mechanism in force at height
+func (bc *Blockchain) consensusAt(height int) ConsensusType {
+	t := bc.schedule[0].Type
+	for _, s := range bc.schedule[1:] {
+		if s.Height > height {
+			break
+		}
+		t = s.Type
+	}
+	return t
+}
+
+// checkConsensus rejects a block produced with a consensus mechanism other
+// than the one in force at its height, as its own Consensus field would
+// otherwise pick the rules it is validated by
+func (bc *Blockchain) checkConsensus(block *Block) error {
+	if want := bc.consensusAt(block.Height); block.Consensus != want {
+		return fmt.Errorf("block uses %s consensus, but %s is in force at height %d", block.Consensus, want, block.Height)
+	}
+	return nil
+}
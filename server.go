@@ -0,0 +1,86 @@
+// Package main implements a read-only REST API for the blockchain
+package main
+
+import (
+	"encoding/hex"  // for parsing block hashes
+	"encoding/json" // for encoding responses
+	"errors"        // for matching store errors
+	"net"           // for listening
+	"net/http"      // for serving requests
+	"strings"       // for parsing request paths
+)
+
+// StartServer serves a read-only view of bc on addr in the background:
+//
+//	GET /blocks         all blocks from genesis to tip
+//	GET /blocks/{hash}  the block with the given hex hash
+//	GET /height         the height of the tip
+//
+// The returned server can be stopped with Shutdown or Close.
+func StartServer(bc *Blockchain, addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, bc)
+	}))
+	mux.HandleFunc("/blocks/", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/blocks/"))
+		if err != nil {
+			http.Error(w, "invalid block hash", http.StatusBadRequest)
+			return
+		}
+
+		block, err := bc.GetBlock(hash)
+		if errors.Is(err, ErrBlockNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, block)
+	}))
+	mux.HandleFunc("/height", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		height, err := bc.Height()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, struct {
+			Height int `json:"height"`
+		}{height})
+	}))
+
+	// Listen before returning so address errors are reported to the caller
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// getOnly rejects requests to handler that don't use GET
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
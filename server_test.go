@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"  // for block URLs
+	"encoding/json" // for decoding responses
+	"net/http"      // for querying the server
+	"sync"          // for waiting on concurrent writers
+	"testing"       // for the test harness
+)
+
+// startTestServer serves bc on a free local port and returns its base URL
+func startTestServer(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	srv, err := StartServer(bc, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + srv.Addr
+}
+
+// getJSON fetches url and decodes the JSON body into v, returning the status
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: decode: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServerEndpoints(t *testing.T) {
+	bc := newTestChain(t, POW)
+	addTestBlocks(t, bc, 2)
+	url := startTestServer(t, bc)
+
+	var height struct{ Height int }
+	if status := getJSON(t, url+"/height", &height); status != http.StatusOK || height.Height != 2 {
+		t.Fatalf("/height = %d, %+v, want 200 and height 2", status, height)
+	}
+
+	var blocks []*Block
+	if status := getJSON(t, url+"/blocks", &blocks); status != http.StatusOK || len(blocks) != 3 {
+		t.Fatalf("/blocks = %d with %d blocks, want 200 and 3", status, len(blocks))
+	}
+
+	tip := tipBlock(t, bc)
+	var block Block
+	if status := getJSON(t, url+"/blocks/"+hex.EncodeToString(tip.Hash), &block); status != http.StatusOK || block.Height != tip.Height {
+		t.Fatalf("/blocks/{tip} = %d, height %d, want 200 and %d", status, block.Height, tip.Height)
+	}
+	if status := getJSON(t, url+"/blocks/"+hex.EncodeToString([]byte("missing")), nil); status != http.StatusNotFound {
+		t.Fatalf("/blocks/{missing} = %d, want 404", status)
+	}
+}
+
+// TestServerDuringMining reads the chain over HTTP while blocks are added.
+// Run with -race to check the chain's locking.
+func TestServerDuringMining(t *testing.T) {
+	bc := newTestChain(t, POW)
+	url := startTestServer(t, bc)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			if err := bc.AddBlock(nil); err != nil {
+				t.Errorf("AddBlock: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		var height struct{ Height int }
+		getJSON(t, url+"/height", &height)
+		var blocks []*Block
+		getJSON(t, url+"/blocks", &blocks)
+	}
+	wg.Wait()
+
+	var height struct{ Height int }
+	if getJSON(t, url+"/height", &height); height.Height != 5 {
+		t.Fatalf("/height = %d after mining, want 5", height.Height)
+	}
+}
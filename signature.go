@@ -0,0 +1,61 @@
+// Package main implements signature verification helpers
+package main
+
+import (
+	"crypto/ecdsa" // for verifying signatures
+	"runtime"      // for sizing the worker pool
+	"sync"         // for waiting on workers
+)
+
+// batchVerifyThreshold is the fewest signatures verifySigChecks hands to
+// BatchVerify. Smaller batches are checked on the calling goroutine, as
+// starting workers would cost more than it saves.
+const batchVerifyThreshold = 16
+
+// SigCheck is a single signature to verify
+type SigCheck struct {
+	PubKey    *ecdsa.PublicKey // key the signature should belong to
+	Message   []byte           // signed digest
+	Signature []byte           // ASN.1 encoded ECDSA signature
+}
+
+// BatchVerify verifies a batch of signatures in parallel and returns the
+// result for each check in the same order as the input
+func BatchVerify(sigs []SigCheck) []bool {
+	results := make([]bool, len(sigs))
+
+	workers := runtime.NumCPU()
+	if workers > len(sigs) {
+		workers = len(sigs)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		// Each worker checks every workers-th signature, so no two
+		// goroutines ever write the same result slot
+		go func(start int) {
+			defer wg.Done()
+			for i := start; i < len(sigs); i += workers {
+				sig := sigs[i]
+				results[i] = sig.PubKey != nil && ecdsa.VerifyASN1(sig.PubKey, sig.Message, sig.Signature)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// verifySigChecks is BatchVerify, checking small batches sequentially
+func verifySigChecks(sigs []SigCheck) []bool {
+	if len(sigs) >= batchVerifyThreshold {
+		return BatchVerify(sigs)
+	}
+
+	results := make([]bool, len(sigs))
+	for i, sig := range sigs {
+		results[i] = sig.PubKey != nil && ecdsa.VerifyASN1(sig.PubKey, sig.Message, sig.Signature)
+	}
+	return results
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ecdsa"    // for signing test messages
+	"crypto/elliptic" // for the P-256 curve
+	"crypto/rand"     // for key generation
+	"crypto/sha256"   // for message digests
+	"fmt"             // for test messages
+	"strings"         // for matching error messages
+	"testing"         // for the test harness
+)
+
+// newSigChecks returns n valid signatures by a few keys
+func newSigChecks(tb testing.TB, n int) []SigCheck {
+	tb.Helper()
+	keys := make([]*ecdsa.PrivateKey, 4)
+	for i := range keys {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		keys[i] = key
+	}
+
+	sigs := make([]SigCheck, n)
+	for i := range sigs {
+		key := keys[i%len(keys)]
+		digest := sha256.Sum256([]byte(fmt.Sprintf("message %d", i)))
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			tb.Fatal(err)
+		}
+		sigs[i] = SigCheck{PubKey: &key.PublicKey, Message: digest[:], Signature: signature}
+	}
+	return sigs
+}
+
+func TestBatchVerify(t *testing.T) {
+	for _, n := range []int{1, batchVerifyThreshold - 1, batchVerifyThreshold, 100} {
+		sigs := newSigChecks(t, n)
+		bad := map[int]bool{0: true, n / 2: true}
+		sigs[0].PubKey = nil
+		sigs[n/2].Signature = append([]byte(nil), sigs[(n/2+1)%n].Signature...)
+
+		for name, results := range map[string][]bool{"BatchVerify": BatchVerify(sigs), "verifySigChecks": verifySigChecks(sigs)} {
+			if len(results) != n {
+				t.Fatalf("%s of %d signatures gave %d results", name, n, len(results))
+			}
+			for i, valid := range results {
+				if valid == bad[i] {
+					t.Errorf("%s of %d signatures: result %d = %t, want %t", name, n, i, valid, !bad[i])
+				}
+			}
+		}
+	}
+}
+
+func TestAddBlockRejectsInvalidSignature(t *testing.T) {
+	bc, w, coinbase := fundedTestChain(t)
+
+	// Changing an output after signing invalidates the signature
+	tx := spendTx(t, bc, w, coinbase, 0, TXOutput{Value: 10, Address: w.Address()})
+	tx.Vout[0].Value = 20
+	tx.ID = tx.Hash()
+
+	err := bc.AddBlock([]*Transaction{tx})
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("AddBlock with a tampered transaction = %v, want signature error", err)
+	}
+}
+
+func BenchmarkVerifySequential1000(b *testing.B) {
+	sigs := newSigChecks(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sig := range sigs {
+			if !ecdsa.VerifyASN1(sig.PubKey, sig.Message, sig.Signature) {
+				b.Fatal("invalid signature")
+			}
+		}
+	}
+}
+
+func BenchmarkBatchVerify1000(b *testing.B) {
+	sigs := newSigChecks(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, valid := range BatchVerify(sigs) {
+			if !valid {
+				b.Fatal("invalid signature")
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package main defines block storage backends
+package main
+
+import (
+	"errors" // for sentinel errors
+	"fmt"    // for error messages
+)
+
+// ErrBlockNotFound is returned by a Store asked for a block it doesn't have
+var ErrBlockNotFound = errors.New("block not found")
+
+// Store abstracts where a Blockchain keeps its blocks
+type Store interface {
+	// Put saves a block keyed by its hash
+	Put(block *Block) error
+	// Get returns the block with the given hash
+	Get(hash []byte) (*Block, error)
+	// Tip returns the hash of the last block, or nil for an empty store
+	Tip() ([]byte, error)
+	// SetTip records the hash of the last block
+	SetTip(hash []byte) error
+	// PutMeta saves chain metadata, such as the consensus schedule, under key
+	PutMeta(key string, value []byte) error
+	// GetMeta returns the metadata saved under key, or nil if there is none
+	GetMeta(key string) ([]byte, error)
+}
+
+// MemoryStore is a Store that keeps blocks in memory
+type MemoryStore struct {
+	blocks map[string]*Block // blocks keyed by hash
+	tip    []byte            // hash of the last block
+	meta   map[string][]byte // chain metadata by key
+}
+
+// NewMemoryStore creates an empty in-memory Store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blocks: make(map[string]*Block), meta: make(map[string][]byte)}
+}
+
+// Put saves a block keyed by its hash
+func (s *MemoryStore) Put(block *Block) error {
+	s.blocks[string(block.Hash)] = block
+	return nil
+}
+
+// Get returns the block with the given hash
+func (s *MemoryStore) Get(hash []byte) (*Block, error) {
+	block, ok := s.blocks[string(hash)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+	return block, nil
+}
+
+// Tip returns the hash of the last block, or nil for an empty store
+func (s *MemoryStore) Tip() ([]byte, error) {
+	return s.tip, nil
+}
+
+// SetTip records the hash of the last block
+func (s *MemoryStore) SetTip(hash []byte) error {
+	s.tip = hash
+	return nil
+}
+
+// PutMeta saves chain metadata under key
+func (s *MemoryStore) PutMeta(key string, value []byte) error {
+	s.meta[key] = value
+	return nil
+}
+
+// GetMeta returns the metadata saved under key, or nil if there is none
+func (s *MemoryStore) GetMeta(key string) ([]byte, error) {
+	return s.meta[key], nil
+}
@@ -0,0 +1,241 @@
+// Package main implements transactions
+package main
+
+import (
+	"bytes"           // for combining byte slices
+	"crypto/ecdsa"    // for signing inputs
+	"crypto/elliptic" // for the P-256 curve
+	"crypto/rand"     // for signing randomness
+	"crypto/sha256"   // for hashing
+	"encoding/hex"    // for keying previous transactions by ID
+	"errors"          // for error values
+	"fmt"             // for error messages
+	"math"            // for detecting overflowing values
+)
+
+// TXInput references an output of a previous transaction being spent
+type TXInput struct {
+	Txid      []byte // ID of the transaction holding the output
+	Vout      int    // index of the output in that transaction
+	Signature []byte // ASN.1 ECDSA signature by the output's owner
+	PubKey    []byte // uncompressed public key of the output's owner
+}
+
+// TXOutput assigns a value to an address
+type TXOutput struct {
+	Value   int    // amount of coins
+	Address []byte // who can spend the output
+}
+
+// Transaction moves value from previous outputs to new outputs
+type Transaction struct {
+	ID   []byte     // hash of the transaction contents
+	Vin  []TXInput  // outputs being spent
+	Vout []TXOutput // outputs being created
+	Data []byte     // arbitrary payload, such as a genesis message
+}
+
+// NewTransaction creates a Transaction and sets its ID
+func NewTransaction(vin []TXInput, vout []TXOutput) *Transaction {
+	tx := &Transaction{Vin: vin, Vout: vout}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewCoinbaseTX creates the transaction paying reward to the producer of a
+// block. data distinguishes coinbases that would otherwise be identical.
+func NewCoinbaseTX(to []byte, data string, reward int) *Transaction {
+	tx := &Transaction{
+		Vout: []TXOutput{{Value: reward, Address: to}},
+		Data: []byte(data),
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// IsCoinbase reports whether the transaction creates coins without spending
+// any. A transaction with neither inputs nor outputs only carries Data, and
+// is an ordinary transaction paying no fee.
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Vin) == 0 && len(tx.Vout) > 0
+}
+
+// Hash returns the hash of the transaction's inputs and outputs
+func (tx *Transaction) Hash() []byte {
+	hash := sha256.Sum256(tx.hashData())
+	return hash[:]
+}
+
+// hashData combines the transaction fields covered by its hash
+func (tx *Transaction) hashData() []byte {
+	var fields [][]byte
+	for _, in := range tx.Vin {
+		fields = append(fields, in.Txid, IntToHex(int64(in.Vout)), in.Signature, in.PubKey)
+	}
+	for _, out := range tx.Vout {
+		fields = append(fields, IntToHex(int64(out.Value)), out.Address)
+	}
+	fields = append(fields, tx.Data)
+	return bytes.Join(fields, []byte{})
+}
+
+// outputValue returns the total value of the outputs, rejecting negative
+// values and totals too large to represent
+func (tx *Transaction) outputValue() (int, error) {
+	total := 0
+	for i, out := range tx.Vout {
+		if out.Value < 0 {
+			return 0, fmt.Errorf("output %d has negative value %d", i, out.Value)
+		}
+		if total > math.MaxInt-out.Value {
+			return 0, errors.New("output values overflow")
+		}
+		total += out.Value
+	}
+	return total, nil
+}
+
+// Fee returns the value of the outputs spent minus the value of the outputs
+// created, which the block producer may claim. prevTXs must hold the spent
+// transactions, keyed by hex ID; inputs missing from it count as zero.
+// Coinbase transactions pay no fee.
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	fee := 0
+	for _, in := range tx.Vin {
+		if prevOut, err := spentOutput(in, prevTXs); err == nil {
+			fee += prevOut.Value
+		}
+	}
+	for _, out := range tx.Vout {
+		fee -= out.Value
+	}
+	return fee
+}
+
+// Sign signs every input with privKey. prevTXs must hold the transactions
+// whose outputs are spent, keyed by hex ID. Since signatures are covered by
+// the transaction hash, the ID is recomputed afterwards.
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	pubKey, err := privKey.PublicKey.Bytes()
+	if err != nil {
+		return err
+	}
+
+	for i, in := range tx.Vin {
+		prevOut, err := spentOutput(in, prevTXs)
+		if err != nil {
+			return err
+		}
+
+		signature, err := ecdsa.SignASN1(rand.Reader, &privKey, tx.signatureHash(i, prevOut.Address))
+		if err != nil {
+			return err
+		}
+		tx.Vin[i].Signature = signature
+		tx.Vin[i].PubKey = pubKey
+	}
+
+	tx.ID = tx.Hash()
+	return nil
+}
+
+// Verify checks that every input is signed by the owner of the output it
+// spends. prevTXs must hold the spent transactions, keyed by hex ID.
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	prevOuts := make([]TXOutput, len(tx.Vin))
+	for i, in := range tx.Vin {
+		prevOut, err := spentOutput(in, prevTXs)
+		if err != nil {
+			return false
+		}
+		prevOuts[i] = prevOut
+	}
+	return tx.verifySignatures(prevOuts)
+}
+
+// verifySignatures checks that each input is signed by the owner of the
+// output it spends, given in prevOuts in input order
+func (tx *Transaction) verifySignatures(prevOuts []TXOutput) bool {
+	checks, ok := tx.sigChecks(prevOuts)
+	if !ok {
+		return false
+	}
+	for _, valid := range verifySigChecks(checks) {
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// sigChecks returns the signature each input must carry, given the outputs
+// they spend in prevOuts in input order. It reports false if an input's key
+// is malformed or doesn't belong to the output's owner.
+func (tx *Transaction) sigChecks(prevOuts []TXOutput) ([]SigCheck, bool) {
+	checks := make([]SigCheck, len(tx.Vin))
+	for i, in := range tx.Vin {
+		prevOut := prevOuts[i]
+
+		// The key must belong to the output's owner...
+		if !bytes.Equal(addressFromPubKey(in.PubKey), prevOut.Address) {
+			return nil, false
+		}
+
+		// ...and must have signed this transaction
+		pubKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), in.PubKey)
+		if err != nil {
+			return nil, false
+		}
+		checks[i] = SigCheck{PubKey: pubKey, Message: tx.signatureHash(i, prevOut.Address), Signature: in.Signature}
+	}
+
+	return checks, true
+}
+
+// signatureHash returns the digest signed for input i: the transaction with
+// all signatures stripped and input i's PubKey replaced by the address of
+// the output it spends
+func (tx *Transaction) signatureHash(i int, address []byte) []byte {
+	txCopy := Transaction{Vout: tx.Vout, Data: tx.Data}
+	for _, in := range tx.Vin {
+		txCopy.Vin = append(txCopy.Vin, TXInput{Txid: in.Txid, Vout: in.Vout})
+	}
+	txCopy.Vin[i].PubKey = address
+
+	return txCopy.Hash()
+}
+
+// spentOutput returns the output an input spends from prevTXs
+func spentOutput(in TXInput, prevTXs map[string]Transaction) (TXOutput, error) {
+	prevTX, ok := prevTXs[hex.EncodeToString(in.Txid)]
+	if !ok {
+		return TXOutput{}, fmt.Errorf("previous transaction %x not found", in.Txid)
+	}
+	if in.Vout < 0 || in.Vout >= len(prevTX.Vout) {
+		return TXOutput{}, fmt.Errorf("transaction %x has no output %d", in.Txid, in.Vout)
+	}
+	return prevTX.Vout[in.Vout], nil
+}
+
+// HashTransactions returns the Merkle root of the transactions. Each leaf
+// hashes to the transaction's Hash.
+func HashTransactions(txs []*Transaction) []byte {
+	var data [][]byte
+	for _, tx := range txs {
+		data = append(data, tx.hashData())
+	}
+
+	return NewMerkleTree(data).RootHash()
+}
@@ -0,0 +1,171 @@
+// Package main implements the unspent transaction output set
+package main
+
+import (
+	"bytes"        // for comparing addresses
+	"encoding/hex" // for keying outputs by transaction ID
+	"fmt"          // for formatting outpoints
+	"sort"         // for iterating outputs in a stable order
+)
+
+// UTXOSet indexes the unspent transaction outputs of a blockchain
+type UTXOSet struct {
+	outputs map[string]map[int]TXOutput // unspent outputs by hex transaction ID and index
+}
+
+// NewUTXOSet creates an empty UTXOSet
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{outputs: make(map[string]map[int]TXOutput)}
+}
+
+// Reindex rebuilds the set from scratch by applying blocks from genesis to tip
+func (u *UTXOSet) Reindex(blocks []*Block) {
+	u.outputs = make(map[string]map[int]TXOutput)
+	for _, block := range blocks {
+		u.Update(block)
+	}
+}
+
+// Update applies a newly added block: outputs it spends are removed and
+// outputs it creates are added
+func (u *UTXOSet) Update(block *Block) {
+	for _, tx := range block.Transactions {
+		for _, in := range tx.Vin {
+			txID := hex.EncodeToString(in.Txid)
+			delete(u.outputs[txID], in.Vout)
+			if len(u.outputs[txID]) == 0 {
+				delete(u.outputs, txID)
+			}
+		}
+
+		if len(tx.Vout) == 0 {
+			continue
+		}
+		txID := hex.EncodeToString(tx.ID)
+		outs := make(map[int]TXOutput, len(tx.Vout))
+		for i, out := range tx.Vout {
+			outs[i] = out
+		}
+		u.outputs[txID] = outs
+	}
+}
+
+// output returns output vout of transaction txid, if it is unspent
+func (u *UTXOSet) output(txid []byte, vout int) (TXOutput, bool) {
+	out, ok := u.outputs[hex.EncodeToString(txid)][vout]
+	return out, ok
+}
+
+// FindSpendableOutputs collects unspent outputs owned by address until they
+// cover amount. It returns the total collected and the output indexes used,
+// keyed by hex transaction ID.
+func (u *UTXOSet) FindSpendableOutputs(address []byte, amount int) (int, map[string][]int) {
+	unspent := make(map[string][]int)
+	accumulated := 0
+
+	for _, txID := range u.sortedIDs() {
+		outs := u.outputs[txID]
+		for _, i := range sortedIndexes(outs) {
+			if accumulated >= amount {
+				return accumulated, unspent
+			}
+			if out := outs[i]; bytes.Equal(out.Address, address) {
+				accumulated += out.Value
+				unspent[txID] = append(unspent[txID], i)
+			}
+		}
+	}
+
+	return accumulated, unspent
+}
+
+// Balance returns the total value of unspent outputs owned by address
+func (u *UTXOSet) Balance(address []byte) int {
+	balance := 0
+	for _, outs := range u.outputs {
+		for _, out := range outs {
+			if bytes.Equal(out.Address, address) {
+				balance += out.Value
+			}
+		}
+	}
+	return balance
+}
+
+// TotalValue returns the total value of all unspent outputs, which is the
+// supply of coins in circulation
+func (u *UTXOSet) TotalValue() int {
+	total := 0
+	for _, outs := range u.outputs {
+		for _, out := range outs {
+			total += out.Value
+		}
+	}
+	return total
+}
+
+// sortedIDs returns the transaction IDs with unspent outputs in a stable order
+func (u *UTXOSet) sortedIDs() []string {
+	ids := make([]string, 0, len(u.outputs))
+	for txID := range u.outputs {
+		ids = append(ids, txID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sortedIndexes returns the output indexes in ascending order
+func sortedIndexes(outs map[int]TXOutput) []int {
+	indexes := make([]int, 0, len(outs))
+	for i := range outs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// utxoView overlays the transactions of a block being checked on a UTXOSet,
+// so each can spend outputs created earlier in the block but no output can
+// be spent twice. The UTXOSet itself is left unchanged.
+type utxoView struct {
+	base    *UTXOSet            // outputs unspent before the block
+	created map[string]TXOutput // outputs created so far, by outpoint
+	spent   map[string]bool     // outpoints spent so far
+}
+
+// newUTXOView creates a view of base with no changes yet
+func newUTXOView(base *UTXOSet) *utxoView {
+	return &utxoView{
+		base:    base,
+		created: make(map[string]TXOutput),
+		spent:   make(map[string]bool),
+	}
+}
+
+// outpoint identifies output vout of transaction txid
+func outpoint(txid []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txid, vout)
+}
+
+// output returns output vout of transaction txid, if it is unspent
+func (v *utxoView) output(txid []byte, vout int) (TXOutput, bool) {
+	key := outpoint(txid, vout)
+	if v.spent[key] {
+		return TXOutput{}, false
+	}
+	if out, ok := v.created[key]; ok {
+		return out, true
+	}
+	return v.base.output(txid, vout)
+}
+
+// apply spends the outputs tx's inputs refer to and makes its own outputs
+// spendable
+func (v *utxoView) apply(tx *Transaction) {
+	for _, in := range tx.Vin {
+		v.spent[outpoint(in.Txid, in.Vout)] = true
+	}
+	for i, out := range tx.Vout {
+		v.created[outpoint(tx.ID, i)] = out
+	}
+}
@@ -0,0 +1,179 @@
+// Package main implements wallets and addresses
+package main
+
+import (
+	"bytes"           // for comparing checksums
+	"crypto/ecdsa"    // for key pairs
+	"crypto/elliptic" // for the P-256 curve
+	"crypto/rand"     // for key generation
+	"crypto/sha256"   // for hashing
+	"crypto/x509"     // for encoding private keys
+	"encoding/gob"    // for the wallet file format
+	"errors"          // for missing-file checks
+	"fmt"             // for error messages
+	"io/fs"           // for missing-file checks
+	"os"              // for reading and writing the wallet file
+	"sort"            // for listing addresses in a stable order
+
+	"golang.org/x/crypto/ripemd160" // for shortening public key hashes
+)
+
+const (
+	addressVersion     = byte(0x00) // version byte prefixed to addresses
+	addressChecksumLen = 4          // bytes of checksum appended to addresses
+)
+
+// Wallet holds a key pair and derives its address from the public key
+type Wallet struct {
+	PrivateKey *ecdsa.PrivateKey // signing key
+	PublicKey  []byte            // uncompressed public key
+}
+
+// NewWallet creates a Wallet with a fresh P-256 key pair
+func NewWallet() (*Wallet, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newWalletFromKey(privKey)
+}
+
+// newWalletFromKey wraps an existing private key in a Wallet
+func newWalletFromKey(privKey *ecdsa.PrivateKey) (*Wallet, error) {
+	pubKey, err := privKey.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{PrivateKey: privKey, PublicKey: pubKey}, nil
+}
+
+// Address returns the wallet's Base58Check address
+func (w *Wallet) Address() []byte {
+	return addressFromPubKey(w.PublicKey)
+}
+
+// HashPubKey hashes a public key with SHA-256 then RIPEMD-160
+func HashPubKey(pubKey []byte) []byte {
+	publicSHA256 := sha256.Sum256(pubKey)
+
+	hasher := ripemd160.New()
+	hasher.Write(publicSHA256[:])
+	return hasher.Sum(nil)
+}
+
+// addressFromPubKey builds a Base58Check address: version byte, public key
+// hash and checksum
+func addressFromPubKey(pubKey []byte) []byte {
+	versionedPayload := append([]byte{addressVersion}, HashPubKey(pubKey)...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+	return Base58Encode(fullPayload)
+}
+
+// ValidateAddress reports whether address is well-formed Base58Check
+func ValidateAddress(address []byte) bool {
+	payload, err := Base58Decode(address)
+	if err != nil || len(payload) <= addressChecksumLen {
+		return false
+	}
+
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	return versionedPayload[0] == addressVersion &&
+		bytes.Equal(actualChecksum, checksum(versionedPayload))
+}
+
+// checksum returns the first bytes of a double SHA-256 of payload
+func checksum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+	return secondSHA[:addressChecksumLen]
+}
+
+// Wallets is a collection of wallets keyed by address
+type Wallets struct {
+	wallets map[string]*Wallet // wallets by address
+}
+
+// NewWallets creates an empty collection
+func NewWallets() *Wallets {
+	return &Wallets{wallets: make(map[string]*Wallet)}
+}
+
+// LoadWallets reads wallets from the file at path. A missing file yields an
+// empty collection.
+func LoadWallets(path string) (*Wallets, error) {
+	ws := NewWallets()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ws, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are stored DER-encoded, as ecdsa keys can't be gob-encoded
+	var keys [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("decode wallets: %w", err)
+	}
+
+	for _, der := range keys {
+		privKey, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse wallet key: %w", err)
+		}
+		wallet, err := newWalletFromKey(privKey)
+		if err != nil {
+			return nil, err
+		}
+		ws.wallets[string(wallet.Address())] = wallet
+	}
+
+	return ws, nil
+}
+
+// CreateWallet adds a new wallet to the collection and returns its address
+func (ws *Wallets) CreateWallet() ([]byte, error) {
+	wallet, err := NewWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	address := wallet.Address()
+	ws.wallets[string(address)] = wallet
+	return address, nil
+}
+
+// GetWallet returns the wallet for address, or nil if there is none
+func (ws *Wallets) GetWallet(address []byte) *Wallet {
+	return ws.wallets[string(address)]
+}
+
+// Addresses returns the addresses of all wallets in a stable order
+func (ws *Wallets) Addresses() [][]byte {
+	var addresses [][]byte
+	for address := range ws.wallets {
+		addresses = append(addresses, []byte(address))
+	}
+	sort.Slice(addresses, func(i, j int) bool { return bytes.Compare(addresses[i], addresses[j]) < 0 })
+	return addresses
+}
+
+// SaveToFile writes the wallets to the file at path
+func (ws *Wallets) SaveToFile(path string) error {
+	var keys [][]byte
+	for _, address := range ws.Addresses() {
+		der, err := x509.MarshalECPrivateKey(ws.wallets[string(address)].PrivateKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, der)
+	}
+
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(keys); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content.Bytes(), 0600)
+}
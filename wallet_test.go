@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"         // for comparing addresses
+	"path/filepath" // for wallet file paths
+	"testing"       // for the test harness
+)
+
+func TestWalletAddressIsValidBase58Check(t *testing.T) {
+	w := newTestWallet(t)
+	address := w.Address()
+	if !ValidateAddress(address) {
+		t.Fatalf("ValidateAddress(%s) = false for a fresh wallet", address)
+	}
+
+	payload, err := Base58Decode(address)
+	if err != nil {
+		t.Fatalf("Base58Decode: %v", err)
+	}
+	if payload[0] != addressVersion {
+		t.Errorf("address version = %#x, want %#x", payload[0], addressVersion)
+	}
+	if got := payload[1 : len(payload)-addressChecksumLen]; !bytes.Equal(got, HashPubKey(w.PublicKey)) {
+		t.Errorf("address payload %x, want public key hash %x", got, HashPubKey(w.PublicKey))
+	}
+
+	// Changing any character breaks the checksum
+	for i := range address {
+		tampered := bytes.Clone(address)
+		if tampered[i] == '2' {
+			tampered[i] = '3'
+		} else {
+			tampered[i] = '2'
+		}
+		if ValidateAddress(tampered) {
+			t.Errorf("ValidateAddress accepted %s, tampered at %d", tampered, i)
+		}
+	}
+	for _, bad := range []string{"", "0OIl", "1"} {
+		if ValidateAddress([]byte(bad)) {
+			t.Errorf("ValidateAddress(%q) = true", bad)
+		}
+	}
+}
+
+func TestWalletsFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallets.dat")
+
+	// A missing file gives an empty collection
+	ws, err := LoadWallets(path)
+	if err != nil {
+		t.Fatalf("LoadWallets of a missing file: %v", err)
+	}
+	if n := len(ws.Addresses()); n != 0 {
+		t.Fatalf("missing file loaded %d wallets, want 0", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := ws.CreateWallet(); err != nil {
+			t.Fatalf("CreateWallet: %v", err)
+		}
+	}
+	if err := ws.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+
+	loaded, err := LoadWallets(path)
+	if err != nil {
+		t.Fatalf("LoadWallets: %v", err)
+	}
+	want, got := ws.Addresses(), loaded.Addresses()
+	if len(got) != len(want) {
+		t.Fatalf("loaded %d wallets, want %d", len(got), len(want))
+	}
+	for i, address := range want {
+		if !bytes.Equal(got[i], address) {
+			t.Fatalf("loaded address %d = %s, want %s", i, got[i], address)
+		}
+		orig, back := ws.GetWallet(address), loaded.GetWallet(address)
+		if !back.PrivateKey.Equal(orig.PrivateKey) || !bytes.Equal(back.PublicKey, orig.PublicKey) {
+			t.Errorf("wallet %s has different keys after loading", address)
+		}
+	}
+}